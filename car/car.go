@@ -0,0 +1,301 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package car reads and writes CAR (Content Addressable aRchive) v1 files, which contain a
+// sequence of blocks, each prefixed by its CID, and a header listing the roots of the archive. See
+// https://ipld.io/specs/transport/car/carv1/.
+package car
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+)
+
+// MaxBlockSize is the maximum size of a block, including its CID, accepted when reading a CAR
+// file; it is much larger than any node created by Ent.
+const MaxBlockSize = 16 << 20
+
+// maxHeaderSize is the maximum size of the header accepted when reading a CAR file.
+const maxHeaderSize = 1 << 20
+
+// Writer writes a CAR file.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter writes the header of a CAR file with the given roots to w, and returns a Writer to
+// which blocks can then be written.
+func NewWriter(w io.Writer, roots []cid.Cid) (*Writer, error) {
+	err := writeSection(w, encodeHeader(roots))
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		w: w,
+	}, nil
+}
+
+// WriteBlock appends a block with the given CID and data.
+func (w *Writer) WriteBlock(c cid.Cid, data []byte) error {
+	return writeSection(w.w, append(c.Bytes(), data...))
+}
+
+func writeSection(w io.Writer, b []byte) error {
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(lenBuf, uint64(len(b)))
+	_, err := w.Write(lenBuf[:n])
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Reader reads a CAR file.
+type Reader struct {
+	r     *bufio.Reader
+	roots []cid.Cid
+}
+
+// NewReader reads the header of a CAR file from r, and returns a Reader from which blocks can then
+// be read.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := readSection(br, maxHeaderSize)
+	if err == io.EOF {
+		return nil, fmt.Errorf("empty CAR file")
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read header: %v", err)
+	}
+	roots, err := decodeHeader(header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+	return &Reader{
+		r:     br,
+		roots: roots,
+	}, nil
+}
+
+// Roots returns the roots listed in the header.
+func (r *Reader) Roots() []cid.Cid {
+	return r.roots
+}
+
+// Next returns the next block, after checking that its data matches its CID, or io.EOF if there
+// are no more blocks.
+func (r *Reader) Next() (cid.Cid, []byte, error) {
+	section, err := readSection(r.r, MaxBlockSize)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	n, c, err := cid.CidFromBytes(section)
+	if err != nil {
+		return cid.Undef, nil, fmt.Errorf("invalid block CID: %v", err)
+	}
+	data := section[n:]
+	expected, err := c.Prefix().Sum(data)
+	if err != nil {
+		return cid.Undef, nil, fmt.Errorf("could not hash block %s: %v", c, err)
+	}
+	if !expected.Equals(c) {
+		return cid.Undef, nil, fmt.Errorf("mismatching hashes for block %s", c)
+	}
+	return c, data, nil
+}
+
+func readSection(r *bufio.Reader, max uint64) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	if l > max {
+		return nil, fmt.Errorf("section too large: %d bytes", l)
+	}
+	b := make([]byte, l)
+	_, err = io.ReadFull(r, b)
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+// The header is the DAG-CBOR encoding of {"roots": [...], "version": 1}; only the subset of CBOR
+// needed to encode and decode it is implemented here.
+
+const (
+	cborUint   = 0
+	cborBytes  = 2
+	cborString = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+
+	// cidTag is the CBOR tag of CIDs in DAG-CBOR.
+	cidTag = 42
+)
+
+func encodeHeader(roots []cid.Cid) []byte {
+	var buf bytes.Buffer
+	writeCBORHead(&buf, cborMap, 2)
+	writeCBORHead(&buf, cborString, 5)
+	buf.WriteString("roots")
+	writeCBORHead(&buf, cborArray, uint64(len(roots)))
+	for _, root := range roots {
+		writeCBORHead(&buf, cborTag, cidTag)
+		// CIDs are prefixed by the identity multibase code.
+		b := append([]byte{0}, root.Bytes()...)
+		writeCBORHead(&buf, cborBytes, uint64(len(b)))
+		buf.Write(b)
+	}
+	writeCBORHead(&buf, cborString, 7)
+	buf.WriteString("version")
+	writeCBORHead(&buf, cborUint, 1)
+	return buf.Bytes()
+}
+
+func writeCBORHead(buf *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.WriteByte(major<<5 | byte(n))
+	case n <= 0xff:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(major<<5 | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= 0xffffffff:
+		buf.WriteByte(major<<5 | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(major<<5 | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+func readCBORHead(r *bytes.Reader) (byte, uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	major := b >> 5
+	info := b & 0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		v, err := r.ReadByte()
+		return major, uint64(v), err
+	case info == 25:
+		var v uint16
+		err := binary.Read(r, binary.BigEndian, &v)
+		return major, uint64(v), err
+	case info == 26:
+		var v uint32
+		err := binary.Read(r, binary.BigEndian, &v)
+		return major, uint64(v), err
+	case info == 27:
+		var v uint64
+		err := binary.Read(r, binary.BigEndian, &v)
+		return major, v, err
+	default:
+		return 0, 0, fmt.Errorf("unsupported CBOR item")
+	}
+}
+
+func readCBORString(r *bytes.Reader, major byte) ([]byte, error) {
+	m, n, err := readCBORHead(r)
+	if err != nil {
+		return nil, err
+	}
+	if m != major || n > uint64(r.Len()) {
+		return nil, fmt.Errorf("unexpected CBOR item")
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
+
+func decodeHeader(b []byte) ([]cid.Cid, error) {
+	r := bytes.NewReader(b)
+	major, n, err := readCBORHead(r)
+	if err != nil {
+		return nil, err
+	}
+	if major != cborMap {
+		return nil, fmt.Errorf("header is not a map")
+	}
+	var roots []cid.Cid
+	version := uint64(0)
+	for i := uint64(0); i < n; i++ {
+		key, err := readCBORString(r, cborString)
+		if err != nil {
+			return nil, err
+		}
+		switch string(key) {
+		case "version":
+			major, version, err = readCBORHead(r)
+			if err != nil {
+				return nil, err
+			}
+			if major != cborUint {
+				return nil, fmt.Errorf("invalid version")
+			}
+		case "roots":
+			major, count, err := readCBORHead(r)
+			if err != nil {
+				return nil, err
+			}
+			if major != cborArray || count > uint64(r.Len()) {
+				return nil, fmt.Errorf("invalid roots")
+			}
+			for j := uint64(0); j < count; j++ {
+				major, tag, err := readCBORHead(r)
+				if err != nil {
+					return nil, err
+				}
+				if major != cborTag || tag != cidTag {
+					return nil, fmt.Errorf("root is not a CID")
+				}
+				cb, err := readCBORString(r, cborBytes)
+				if err != nil {
+					return nil, err
+				}
+				if len(cb) == 0 || cb[0] != 0 {
+					return nil, fmt.Errorf("invalid CID prefix")
+				}
+				c, err := cid.Cast(cb[1:])
+				if err != nil {
+					return nil, err
+				}
+				roots = append(roots, c)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected key %q", key)
+		}
+	}
+	if version != 1 {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+	return roots, nil
+}
@@ -0,0 +1,179 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package car
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/google/ent/nodeservice"
+	"github.com/google/ent/utils"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/multiformats/go-multihash"
+)
+
+// importBatchSize and importBatchBytes are the maximum number and total size of blocks checked and
+// added to the node service together, which bound the memory used by Import.
+const importBatchSize = 1000
+const importBatchBytes = 64 << 20
+
+// exportBatchSize is the maximum number of nodes fetched together by Export, which bounds the
+// memory it uses, since nodes, such as the chunks of large files, can be up to utils.ChunkSize.
+const exportBatchSize = 64
+
+// Export writes a CAR file containing the DAG under root to w. Nodes are written breadth-first,
+// following links in order, and each node is only written once. Only the CIDs of the nodes still
+// to be written are kept in memory, and nodes are fetched in batches of exportBatchSize.
+func Export(ctx context.Context, ns nodeservice.NodeService, root cid.Cid, w io.Writer) (int, error) {
+	cw, err := NewWriter(w, []cid.Cid{root})
+	if err != nil {
+		return 0, err
+	}
+	seen := map[cid.Cid]bool{root: true}
+	frontier := []cid.Cid{root}
+	count := 0
+	for len(frontier) > 0 {
+		next := []cid.Cid{}
+		for start := 0; start < len(frontier); start += exportBatchSize {
+			end := start + exportBatchSize
+			if end > len(frontier) {
+				end = len(frontier)
+			}
+			batch := frontier[start:end]
+			nodes := map[cid.Cid]format.Node{}
+			for o := range ns.GetMany(ctx, batch) {
+				if o.Err != nil {
+					return count, o.Err
+				}
+				nodes[o.Node.Cid()] = o.Node
+			}
+			for _, c := range batch {
+				node, ok := nodes[c]
+				if !ok {
+					return count, fmt.Errorf("could not get node %s", c)
+				}
+				err := cw.WriteBlock(c, node.RawData())
+				if err != nil {
+					return count, err
+				}
+				count++
+				for _, l := range node.Links() {
+					if !seen[l.Cid] {
+						seen[l.Cid] = true
+						next = append(next, l.Cid)
+					}
+				}
+			}
+		}
+		frontier = next
+	}
+	return count, nil
+}
+
+// ImportResult summarizes the outcome of Import.
+type ImportResult struct {
+	Roots []cid.Cid
+	// Blocks is the number of blocks in the file, and Added the number of those that were not
+	// already present.
+	Blocks int
+	Added  int
+}
+
+// Import reads a CAR file from r and adds all its blocks to ns, after checking that each block
+// matches its CID and is a valid node. Blocks already present are not uploaded again.
+func Import(ctx context.Context, ns nodeservice.NodeService, r io.Reader) (ImportResult, error) {
+	res := ImportResult{}
+	cr, err := NewReader(r)
+	if err != nil {
+		return res, err
+	}
+	res.Roots = cr.Roots()
+
+	batch := []format.Node{}
+	batchBytes := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		hashes := []multihash.Multihash{}
+		for _, node := range batch {
+			hashes = append(hashes, node.Cid().Hash())
+		}
+		missing, err := ns.MissingObjects(ctx, hashes)
+		if err != nil {
+			return fmt.Errorf("could not check missing objects: %v", err)
+		}
+		missingSet := map[string]bool{}
+		for _, h := range missing {
+			missingSet[string(h)] = true
+		}
+		nodes := []format.Node{}
+		for _, node := range batch {
+			if missingSet[string(node.Cid().Hash())] {
+				nodes = append(nodes, node)
+				delete(missingSet, string(node.Cid().Hash()))
+			}
+		}
+		batch = nil
+		batchBytes = 0
+		if len(nodes) > 0 {
+			// Nodes, unlike objects, are added with the hash function of their CID.
+			err := ns.AddMany(ctx, nodes)
+			if err != nil {
+				return fmt.Errorf("could not add objects: %v", err)
+			}
+		}
+		res.Added += len(nodes)
+		return nil
+	}
+
+	for {
+		c, data, err := cr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return res, err
+		}
+		// Objects are stored by their multihash, whose hash function must be supported.
+		_, _, err = utils.NewHasher(c.Hash())
+		if err != nil {
+			return res, fmt.Errorf("unsupported hash function for block %s: %v", c, err)
+		}
+		node, err := utils.ParseNodeFromBytes(c, data)
+		if err != nil {
+			return res, fmt.Errorf("invalid block %s: %v", c, err)
+		}
+		if !node.Cid().Equals(c) {
+			return res, fmt.Errorf("invalid block %s: not canonically encoded", c)
+		}
+		batch = append(batch, node)
+		batchBytes += len(data)
+		res.Blocks++
+		if len(batch) >= importBatchSize || batchBytes >= importBatchBytes {
+			err := flush()
+			if err != nil {
+				return res, err
+			}
+		}
+	}
+	err = flush()
+	if err != nil {
+		return res, err
+	}
+	return res, nil
+}
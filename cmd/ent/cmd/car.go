@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/google/ent/car"
+	"github.com/ipfs/go-cid"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:  "export [hash]",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		root, err := cid.Decode(args[0])
+		if err != nil {
+			log.Fatalf("could not decode cid: %v", err)
+		}
+		var w io.Writer = os.Stdout
+		if exportOutput != "-" {
+			f, err := os.Create(exportOutput)
+			if err != nil {
+				log.Fatalf("could not create output file: %v", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		bw := bufio.NewWriter(w)
+		count, err := car.Export(context.Background(), nodeService, root, bw)
+		if err != nil {
+			log.Fatalf("could not export: %v", err)
+		}
+		err = bw.Flush()
+		if err != nil {
+			log.Fatalf("could not write output: %v", err)
+		}
+		log.Printf("exported %d blocks", count)
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:  "import [file]",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var r io.Reader = os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				log.Fatalf("could not open input file: %v", err)
+			}
+			defer f.Close()
+			r = f
+		}
+		res, err := car.Import(context.Background(), nodeService, r)
+		if err != nil {
+			log.Fatalf("could not import: %v", err)
+		}
+		for _, root := range res.Roots {
+			fmt.Printf("%s\n", color.YellowString(root.String()))
+		}
+		log.Printf("imported %d blocks (%d new)", res.Blocks, res.Added)
+	},
+}
@@ -3,10 +3,12 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 
+	"github.com/google/ent/nodeservice"
 	"github.com/google/ent/utils"
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
@@ -31,34 +33,55 @@ var catCmd = &cobra.Command{
 
 		pathSegments := filepath.SplitList(filePath)
 
-		var node format.Node
-
-		for {
+		for len(pathSegments) > 0 {
 			obj, err := nodeService.GetObject(context.Background(), base.Hash())
 			if err != nil {
 				log.Fatalf("could not fetch object: %v", err)
 			}
-			node, err = utils.ParseNodeFromBytes(base, obj)
-			if len(pathSegments) > 0 {
-				s := pathSegments[0]
-				pathSegments = pathSegments[1:]
+			node, err := utils.ParseNodeFromBytes(base, obj)
+			if err != nil {
+				log.Fatalf("could not parse node: %v", err)
+			}
+			s := pathSegments[0]
+			pathSegments = pathSegments[1:]
 
-				switch node := node.(type) {
-				case *merkledag.ProtoNode:
-					link, err := node.GetNodeLink(s)
-					if err != nil {
-						log.Fatalf("could not get node link: %v", err)
-					}
-					base = link.Cid
-				case *merkledag.RawNode:
-					log.Fatalf("invalid state")
+			switch node := node.(type) {
+			case *merkledag.ProtoNode:
+				link, err := node.GetNodeLink(s)
+				if err != nil {
+					log.Fatalf("could not get node link: %v", err)
 				}
-			} else {
-				break
+				base = link.Cid
+			case *merkledag.RawNode:
+				log.Fatalf("invalid state")
+			}
+		}
+
+		if base.Prefix().Codec != cid.Raw {
+			obj, err := nodeService.GetObject(context.Background(), base.Hash())
+			if err != nil {
+				log.Fatalf("could not fetch object: %v", err)
+			}
+			node, err := utils.ParseNodeFromBytes(base, obj)
+			if err != nil {
+				log.Fatalf("could not parse node: %v", err)
+			}
+			if !utils.IsChunkedFile(node) {
+				os.Stdout.Write(printNode(node))
+				return
 			}
 		}
 
-		os.Stdout.Write(printNode(node))
+		// Stream files, which may be arbitrarily large.
+		r, err := nodeservice.NewFileReader(context.Background(), nodeService, base)
+		if err != nil {
+			log.Fatalf("could not fetch file: %v", err)
+		}
+		defer r.Close()
+		_, err = io.Copy(os.Stdout, r)
+		if err != nil {
+			log.Fatalf("could not read file: %v", err)
+		}
 	},
 }
 
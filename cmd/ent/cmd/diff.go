@@ -9,6 +9,7 @@ import (
 	"github.com/google/ent/datastore"
 	"github.com/google/ent/nodeservice"
 	"github.com/google/ent/objectstore"
+	"github.com/google/ent/utils"
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-merkledag/dagutils"
@@ -67,7 +68,7 @@ func buildInMemory(path string) (cid.Cid, nodeservice.DataStore) {
 		return nil
 	}
 	i := parseIgnore(path)
-	hash := traverse(path, "", i, f)
+	hash := traverse(path, "", i, utils.DefaultCidPrefix(), f)
 	return hash, s
 }
 
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/fatih/color"
+	"github.com/google/ent/gc"
+	"github.com/google/ent/nodeservice"
+	"github.com/google/ent/translog"
+	"github.com/ipfs/go-cid"
+	"github.com/spf13/cobra"
+)
+
+var gcCmd = &cobra.Command{
+	Use:  "gc",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		ds, ok := nodeService.(nodeservice.DataStore)
+		if !ok {
+			log.Fatal("gc is only supported for path remotes")
+		}
+
+		roots, err := gc.TagRoots(ctx, nodeService, tagStore, []string{translog.HeadTag}, gcHistory)
+		if err != nil {
+			log.Fatalf("could not get roots from tags: %v", err)
+		}
+		for _, pin := range gcPins {
+			root, err := cid.Decode(pin)
+			if err != nil {
+				log.Fatalf("could not decode pin %q: %v", pin, err)
+			}
+			roots = append(roots, root)
+		}
+
+		report, err := gc.Run(ctx, nodeService, ds.Inner, gc.Options{
+			Roots:       roots,
+			GracePeriod: gcGracePeriod,
+			DryRun:      gcDryRun,
+		})
+		if err != nil {
+			log.Fatalf("could not run gc: %v", err)
+		}
+		marker := color.RedString("-")
+		if gcDryRun {
+			marker = color.YellowString("?")
+		}
+		for _, o := range report.Swept {
+			fmt.Printf("%s %s %d\n", color.YellowString(o.Hash.HexString()), marker, o.Size)
+		}
+		fmt.Printf("roots: %d, reachable: %d, missing: %d, kept (grace period): %d, swept: %d (%d bytes)\n", len(roots), report.Reachable, report.Missing, report.Kept, len(report.Swept), report.SweptBytes)
+	},
+}
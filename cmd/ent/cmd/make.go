@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"path/filepath"
 
@@ -28,11 +30,39 @@ var makeCmd = &cobra.Command{
 		log.Printf("plan: %#v", plan)
 
 		for _, o := range plan.Overrides {
-			base, err := cid.Decode(o.From)
-			if err != nil {
-				log.Fatalf("could not decode cid: %v", err)
+			var base cid.Cid
+			if o.Tag != "" {
+				if o.From != "" {
+					log.Fatalf("override %q has both from and tag", o.Path)
+				}
+				base, err = resolveTrustedTag(context.Background(), o.Tag)
+				if err != nil {
+					log.Fatalf("could not resolve tag %q: %v", o.Tag, err)
+				}
+			} else {
+				base, err = cid.Decode(o.From)
+				if err != nil {
+					log.Fatalf("could not decode cid: %v", err)
+				}
 			}
 			pull(base, o.Path, o.Executable)
 		}
 	},
 }
+
+// resolveTrustedTag returns the root that a tag points to, after checking that its value is signed
+// by one of the trusted keys.
+func resolveTrustedTag(ctx context.Context, name string) (cid.Cid, error) {
+	value, err := tagStore.Get(ctx, name)
+	if err != nil {
+		return cid.Undef, err
+	}
+	target, signed, err := verifyTag(name, value)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if signed == nil {
+		return cid.Undef, fmt.Errorf("tag is not signed")
+	}
+	return cid.Decode(target)
+}
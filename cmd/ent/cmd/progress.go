@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often the progress line is redrawn.
+const progressInterval = 200 * time.Millisecond
+
+// progress counts the objects hashed, uploaded and skipped by push and, if stderr is a terminal,
+// displays the counts and the upload rate on a single line that is redrawn periodically. Counters
+// are updated atomically.
+type progress struct {
+	hashedObjects   int64
+	hashedBytes     int64
+	uploadedObjects int64
+	uploadedBytes   int64
+	skippedObjects  int64
+	skippedBytes    int64
+
+	start    time.Time
+	terminal bool
+	// mu serializes output, so that other lines do not get mixed up with the progress line.
+	mu      sync.Mutex
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newProgress() *progress {
+	p := &progress{
+		start:   time.Now(),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	info, err := os.Stderr.Stat()
+	p.terminal = err == nil && info.Mode()&os.ModeCharDevice != 0
+	if !p.terminal {
+		close(p.stopped)
+		return p
+	}
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.mu.Lock()
+				fmt.Fprintf(os.Stderr, "\r\033[K%s", p)
+				p.mu.Unlock()
+			case <-p.done:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// add counts an object of n bytes.
+func (p *progress) add(objects *int64, bytes *int64, n int) {
+	atomic.AddInt64(objects, 1)
+	atomic.AddInt64(bytes, int64(n))
+}
+
+// println prints lines to stdout, above the progress line.
+func (p *progress) println(lines []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.terminal {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+	for _, l := range lines {
+		fmt.Println(l)
+	}
+}
+
+// stop stops redrawing the progress line, and clears it.
+func (p *progress) stop() {
+	if p.terminal {
+		close(p.done)
+	}
+	<-p.stopped
+	if p.terminal {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+}
+
+func (p *progress) String() string {
+	uploadedBytes := atomic.LoadInt64(&p.uploadedBytes)
+	rate := float64(uploadedBytes) / time.Since(p.start).Seconds()
+	return fmt.Sprintf("hashed %d objects (%s), uploaded %d objects (%s, %s/s), %d already present (%s)",
+		atomic.LoadInt64(&p.hashedObjects), formatBytes(atomic.LoadInt64(&p.hashedBytes)),
+		atomic.LoadInt64(&p.uploadedObjects), formatBytes(uploadedBytes), formatBytes(int64(rate)),
+		atomic.LoadInt64(&p.skippedObjects), formatBytes(atomic.LoadInt64(&p.skippedBytes)))
+}
+
+// formatBytes formats a number of bytes with a binary unit prefix.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -2,16 +2,21 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
+	"github.com/google/ent/nodeservice"
 	"github.com/google/ent/utils"
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-merkledag/dagutils"
 	"github.com/spf13/cobra"
 )
 
@@ -40,55 +45,333 @@ var pullCmd = &cobra.Command{
 	},
 }
 
+// pullChange is a change to make to the target path of pull. For additions and modifications,
+// Metadata is the metadata of the new entry. Modifications where Before and After are equal only
+// change the metadata.
+type pullChange struct {
+	Type     dagutils.ChangeType
+	Path     string
+	Before   cid.Cid
+	After    cid.Cid
+	Metadata utils.Metadata
+}
+
+// localEntry is what pull keeps of a local file, as hashed by traverse: its CID and, for
+// directories, their links and the metadata of their entries, but not the content of files.
+type localEntry struct {
+	Cid cid.Cid
+	// Dir is set for directories, but not for chunked files.
+	Dir      bool
+	Links    []*format.Link
+	Metadata map[string]utils.Metadata
+}
+
+// newLocalEntry returns the localEntry of node.
+func newLocalEntry(node format.Node) (localEntry, error) {
+	e := localEntry{Cid: node.Cid()}
+	dir, ok := node.(*merkledag.ProtoNode)
+	if !ok || utils.IsChunkedFile(dir) {
+		return e, nil
+	}
+	metadata, err := utils.GetMetadata(dir)
+	if err != nil {
+		return localEntry{}, err
+	}
+	e.Dir = true
+	e.Links = dir.Links()
+	e.Metadata = metadata
+	return e, nil
+}
+
+// pull reconciles targetPath with the node base: only nodes that differ from the local files are
+// fetched, and local files that are not part of base are deleted. If this would lose local changes,
+// i.e. files whose contents are not present in the remote, pull fails unless pullForce is set.
+//
+// Files and directories get the modes recorded in base, and symbolic links are recreated. If
+// executable is set, targetPath itself is made executable.
 func pull(base cid.Cid, targetPath string, executable bool) {
-	_, err := os.Stat(targetPath)
+	ctx := context.Background()
+	rootMetadata := utils.Metadata{}
+	if executable {
+		rootMetadata.Mode = 0755
+	}
+	// The mode of targetPath itself is only checked if it must be executable.
+	localRootMetadata := rootMetadata
+	local := map[string]localEntry{}
+	info, err := os.Stat(targetPath)
 	if os.IsNotExist(err) {
 		// Continue.
 	} else if err != nil {
 		log.Fatalf("could not stat target path: %v", err)
+	} else {
+		// Local files are hashed with the hash function of base, so that unchanged files match
+		// even if it is not the default one.
+		prefix := utils.DefaultCidPrefix()
+		prefix.MhType = base.Prefix().MhType
+		traverse(targetPath, "", parseIgnore(targetPath), prefix, func(p string, node format.Node) error {
+			e, err := newLocalEntry(node)
+			if err != nil {
+				return err
+			}
+			local[p] = e
+			return nil
+		})
+		if executable && info.Mode().Perm() != 0755 {
+			localRootMetadata = utils.NewMetadata(info)
+		}
 	}
-	if !os.IsNotExist(err) {
-		log.Printf("target path %s already exists; skipping", targetPath)
-		// Skip?
+
+	changes, err := pullChanges(ctx, base, rootMetadata, localRootMetadata, "", local)
+	if err != nil {
+		log.Fatalf("could not compare %s with %q: %v", base, targetPath, err)
+	}
+	if len(changes) == 0 {
+		log.Printf("%s is up to date", targetPath)
 		return
 	}
-	files, err := ioutil.ReadDir(targetPath)
-	if os.IsNotExist(err) {
-		// Continue.
-	} else if err != nil {
-		log.Fatalf("could not read target directory %q: %v", targetPath, err)
+
+	var lost []string
+	for _, c := range changes {
+		displayPath := filepath.Join(targetPath, c.Path)
+		switch c.Type {
+		case dagutils.Add:
+			fmt.Printf("+ %s\n", displayPath)
+		case dagutils.Remove:
+			fmt.Printf("- %s\n", displayPath)
+		case dagutils.Mod:
+			fmt.Printf("* %s\n", displayPath)
+		}
+		if c.Type == dagutils.Add || c.Before == c.After {
+			continue
+		}
+		ok, err := nodeService.Has(ctx, c.Before)
+		if err != nil {
+			log.Fatalf("could not check whether %s is in the remote: %v", displayPath, err)
+		}
+		if !ok {
+			lost = append(lost, displayPath)
+		}
 	}
-	if len(files) > 0 {
-		log.Fatalf("cannot pull to non-empty directory %q", targetPath)
+	for _, p := range lost {
+		log.Printf("local changes to %s would be lost", p)
 	}
-	traverseRemote(base, "", func(p string, node format.Node) error {
-		fullPath := filepath.Join(targetPath, p)
-		log.Printf("%s\n", fullPath)
-		switch node := node.(type) {
-		case *merkledag.ProtoNode:
-			err := os.MkdirAll(fullPath, 0755)
+	if pullDryRun {
+		return
+	}
+	if len(lost) > 0 && !pullForce {
+		log.Fatalf("not pulling to %q, since local changes would be lost; use --force to overwrite them", targetPath)
+	}
+
+	var added, removed, modified int
+	// Directory modes are applied last, so that read-only directories can be populated first.
+	var dirs []string
+	var dirModes []os.FileMode
+	for _, c := range changes {
+		// Removing an entry does not follow it if it is a symbolic link.
+		fullPath := pullPath(targetPath, c.Path, true)
+		switch c.Type {
+		case dagutils.Add:
+			added++
+		case dagutils.Remove:
+			removed++
+		case dagutils.Mod:
+			modified++
+		}
+		if c.Type == dagutils.Mod && c.Before == c.After && !c.Metadata.Symlink {
+			info, err := os.Lstat(fullPath)
 			if err != nil {
-				log.Fatalf("could not create directory %q: %v", fullPath, err)
+				log.Fatalf("could not stat %q: %v", fullPath, err)
 			}
-		case *merkledag.RawNode:
-			err := os.MkdirAll(path.Dir(fullPath), 0755)
-			if err != nil {
-				log.Fatalf("could not create directory %q: %v", fullPath, err)
+			if info.Mode()&os.ModeSymlink == 0 {
+				if info.IsDir() {
+					dirs = append(dirs, fullPath)
+					dirModes = append(dirModes, c.Metadata.FileMode(true))
+				} else {
+					err := os.Chmod(fullPath, c.Metadata.FileMode(false))
+					if err != nil {
+						log.Fatalf("could not change mode of %q: %v", fullPath, err)
+					}
+				}
+				continue
 			}
-			mode := 0644
-			if executable {
-				mode = 0755
+		}
+		if c.Type != dagutils.Add {
+			err := os.RemoveAll(fullPath)
+			if err != nil {
+				log.Fatalf("could not remove %q: %v", fullPath, err)
 			}
-			err = ioutil.WriteFile(fullPath, node.RawData(), os.FileMode(mode))
+		}
+		if c.Type != dagutils.Remove {
+			traverseRemote(c.After, c.Metadata, c.Path, func(p string, node format.Node, m utils.Metadata) error {
+				fullPath := pullPath(targetPath, p, false)
+				if writeNode(node, fullPath, m) {
+					dirs = append(dirs, fullPath)
+					dirModes = append(dirModes, m.FileMode(true))
+				}
+				return nil
+			})
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		err := os.Chmod(dirs[i], dirModes[i])
+		if err != nil {
+			log.Fatalf("could not change mode of %q: %v", dirs[i], err)
+		}
+	}
+	log.Printf("pulled %s to %s: %d added, %d removed, %d modified", base, targetPath, added, removed, modified)
+}
+
+// pullChanges returns the changes needed to turn the local files under p, as hashed by traverse,
+// into the node c with metadata m. localMetadata is the metadata of the local file at p. Subtrees
+// whose hash matches the local one are not fetched.
+func pullChanges(ctx context.Context, c cid.Cid, m utils.Metadata, localMetadata utils.Metadata, p string, local map[string]localEntry) ([]pullChange, error) {
+	entry, ok := local[p]
+	if !ok {
+		return []pullChange{{Type: dagutils.Add, Path: p, After: c, Metadata: m}}, nil
+	}
+	if entry.Cid == c {
+		if m != localMetadata {
+			return []pullChange{{Type: dagutils.Mod, Path: p, Before: c, After: c, Metadata: m}}, nil
+		}
+		return nil, nil
+	}
+	node, err := nodeService.Get(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("could not get node %s: %v", c, err)
+	}
+	dir, ok := node.(*merkledag.ProtoNode)
+	if !ok || !entry.Dir || utils.IsChunkedFile(dir) || m.Symlink || localMetadata.Symlink {
+		return []pullChange{{Type: dagutils.Mod, Path: p, Before: entry.Cid, After: c, Metadata: m}}, nil
+	}
+
+	err = utils.CheckLinks(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid directory %s: %v", c, err)
+	}
+	metadata, err := utils.GetMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	var changes []pullChange
+	if m != localMetadata {
+		changes = append(changes, pullChange{Type: dagutils.Mod, Path: p, Before: c, After: c, Metadata: m})
+	}
+	for _, l := range dir.Links() {
+		cc, err := pullChanges(ctx, l.Cid, metadata[l.Name], entry.Metadata[l.Name], path.Join(p, l.Name), local)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, cc...)
+	}
+	for _, l := range entry.Links {
+		_, err := dir.GetNodeLink(l.Name)
+		if err == merkledag.ErrLinkNotFound {
+			changes = append(changes, pullChange{Type: dagutils.Remove, Path: path.Join(p, l.Name), Before: l.Cid})
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// pullPath returns the path of the entry at the relative path p under targetPath, and exits if it
+// is not under targetPath, or if it would be reached through a symbolic link, including one created
+// earlier by the same pull. The entry itself may only be a symbolic link if allowSymlink is set.
+func pullPath(targetPath string, p string, allowSymlink bool) string {
+	fullPath := filepath.Join(targetPath, filepath.FromSlash(p))
+	rel, err := filepath.Rel(targetPath, fullPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		log.Fatalf("refusing to write %q outside of %q", p, targetPath)
+	}
+	if rel == "." {
+		return fullPath
+	}
+	segments := strings.Split(rel, string(filepath.Separator))
+	current := targetPath
+	for i, segment := range segments {
+		current = filepath.Join(current, segment)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			log.Fatalf("could not stat %q: %v", current, err)
+		}
+		if info.Mode()&os.ModeSymlink != 0 && (i < len(segments)-1 || !allowSymlink) {
+			log.Fatalf("refusing to write %q through symbolic link %q", fullPath, current)
+		}
+	}
+	return fullPath
+}
+
+// writeNode creates the file, directory or symbolic link at fullPath from a node returned by
+// traverseRemote, and returns whether it is a directory, whose mode is left to the caller.
+func writeNode(node format.Node, fullPath string, m utils.Metadata) bool {
+	switch node := node.(type) {
+	case *merkledag.ProtoNode:
+		if utils.IsChunkedFile(node) {
+			pullChunkedFile(node, fullPath, m.FileMode(false))
+			return false
+		}
+		err := os.MkdirAll(fullPath, 0755)
+		if err != nil {
+			log.Fatalf("could not create directory %q: %v", fullPath, err)
+		}
+		return true
+	case *merkledag.RawNode:
+		err := os.MkdirAll(path.Dir(fullPath), 0755)
+		if err != nil {
+			log.Fatalf("could not create directory %q: %v", fullPath, err)
+		}
+		if m.Symlink {
+			err = os.Symlink(string(node.RawData()), fullPath)
 			if err != nil {
-				log.Fatalf("could not create file %q: %v", fullPath, err)
+				log.Fatalf("could not create symbolic link %q: %v", fullPath, err)
 			}
+			return false
 		}
-		return nil
-	})
+		err = ioutil.WriteFile(fullPath, node.RawData(), m.FileMode(false))
+		if err != nil {
+			log.Fatalf("could not create file %q: %v", fullPath, err)
+		}
+		// The mode passed to WriteFile is subject to the umask.
+		err = os.Chmod(fullPath, m.FileMode(false))
+		if err != nil {
+			log.Fatalf("could not change mode of %q: %v", fullPath, err)
+		}
+	}
+	return false
+}
+
+// pullChunkedFile streams the chunks of a large file to disk one at a time.
+func pullChunkedFile(node *merkledag.ProtoNode, fullPath string, mode os.FileMode) {
+	err := os.MkdirAll(path.Dir(fullPath), 0755)
+	if err != nil {
+		log.Fatalf("could not create directory %q: %v", fullPath, err)
+	}
+	r, err := nodeservice.NewFileReader(context.Background(), nodeService, node.Cid())
+	if err != nil {
+		log.Fatalf("could not read file %q: %v", fullPath, err)
+	}
+	defer r.Close()
+	file, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		log.Fatalf("could not create file %q: %v", fullPath, err)
+	}
+	defer file.Close()
+	_, err = io.Copy(file, r)
+	if err != nil {
+		log.Fatalf("could not write file %q: %v", fullPath, err)
+	}
+	// The mode passed to OpenFile is subject to the umask.
+	err = file.Chmod(mode)
+	if err != nil {
+		log.Fatalf("could not change mode of %q: %v", fullPath, err)
+	}
 }
 
-func traverseRemote(base cid.Cid, relativeFilename string, f func(string, format.Node) error) {
+// traverseRemote invokes f on base, which has metadata m, and recursively on all the entries of
+// directories, along with their metadata.
+func traverseRemote(base cid.Cid, m utils.Metadata, relativeFilename string, f func(string, format.Node, utils.Metadata) error) {
 	obj, err := nodeService.GetObject(context.Background(), base.Hash())
 	if err != nil {
 		log.Fatal(err)
@@ -100,24 +383,35 @@ func traverseRemote(base cid.Cid, relativeFilename string, f func(string, format
 		if err != nil {
 			log.Fatal(err)
 		}
-		err = f(relativeFilename, node)
+		// Keep the hash function of the remote node, which may differ from the default one.
+		node.SetCidBuilder(base.Prefix())
+		err = f(relativeFilename, node, m)
 		if err != nil {
 			log.Fatal(err)
 		}
+		// The chunks of large files are written by f.
+		if utils.IsChunkedFile(node) {
+			return
+		}
 
+		err = utils.CheckLinks(node)
+		if err != nil {
+			log.Fatalf("invalid directory %s: %v", base, err)
+		}
+		metadata, err := utils.GetMetadata(node)
+		if err != nil {
+			log.Fatal(err)
+		}
 		for _, l := range node.Links() {
-			if l.Name == "" {
-				continue
-			}
 			newRelativeFilename := path.Join(relativeFilename, l.Name)
-			traverseRemote(l.Cid, newRelativeFilename, f)
+			traverseRemote(l.Cid, metadata[l.Name], newRelativeFilename, f)
 		}
 	case cid.Raw:
-		node, err := utils.ParseRawNode(obj)
+		node, err := utils.ParseNodeFromBytes(base, obj)
 		if err != nil {
 			log.Fatal(err)
 		}
-		err = f(relativeFilename, node)
+		err = f(relativeFilename, node, m)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -1,15 +1,20 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/fatih/color"
-	"github.com/google/ent/nodeservice"
+	"github.com/google/ent/tagstore"
 	"github.com/google/ent/utils"
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
+	"github.com/multiformats/go-multihash"
 	"github.com/spf13/cobra"
 )
 
@@ -21,37 +26,268 @@ var pushCmd = &cobra.Command{
 		if len(args) > 0 {
 			target = args[0]
 		}
+		// Check the tag name before uploading anything.
+		if tagName != "" {
+			err := tagstore.ValidateName(tagName)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
 		i := parseIgnore(target)
-		hash := traverse(target, "", i, push)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		p := newPusher(ctx, cancel, pushJobs)
+		hash, walkErr := walk(target, "", i, utils.DefaultCidPrefix(), pushJobs, p.push)
+		errs := p.wait(walkErr)
+		if len(errs) > 0 {
+			printPushErrors(errs)
+			os.Exit(1)
+		}
+		log.Printf("pushed %s: %s", hash, p.summary())
 		if tagName != "" {
-			tagStore.Set(context.Background(), tagName, []byte(utils.Hash(hash)))
+			err := setTag(context.Background(), tagName, hash, cmd.Flags().Changed("expect"))
+			if err != nil {
+				log.Fatalf("could not set tag %q: %v", tagName, err)
+			}
 		}
 	},
 }
 
-func push(filename string, node format.Node) error {
+// setTag points the tag to the pushed root, signing the new value if a signing key is configured.
+// If checkExpected is set, the tag is only updated if it currently points to expectedTagValue, or
+// does not exist if that is empty.
+func setTag(ctx context.Context, name string, root cid.Cid, checkExpected bool) error {
+	key, err := loadSigningKey()
+	if err != nil {
+		return err
+	}
+	current, err := tagStore.Get(ctx, name)
+	if err == tagstore.ErrNotFound {
+		current = nil
+	} else if err != nil {
+		return err
+	}
+	if checkExpected {
+		if expectedTagValue == "" && current != nil {
+			return fmt.Errorf("tag already exists")
+		}
+		if expectedTagValue != "" {
+			currentTarget, _, err := tagstore.ParseValue(current)
+			if err != nil {
+				return err
+			}
+			if current == nil || currentTarget != expectedTagValue {
+				return fmt.Errorf("tag points to %q instead of %q", currentTarget, expectedTagValue)
+			}
+		}
+	}
+
+	var value []byte
+	if key != nil {
+		value, err = tagstore.Sign(key, name, root.String(), nextSeq(current), time.Now()).Marshal()
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Printf("no signing_key configured, tag %q will not be signed", name)
+		value = []byte(root.String())
+	}
+	if checkExpected || key != nil {
+		// Make sure that the tag has not changed since it was checked, and that the sequence number
+		// is still correct.
+		return tagStore.CompareAndSet(ctx, name, current, value)
+	}
+	return tagStore.Set(ctx, name, value)
+}
+
+const (
+	// pushBatchSize is the maximum number of nodes checked and uploaded together.
+	pushBatchSize = 1000
+	// pushBatchBytes is the size after which pending nodes are uploaded, even if there are fewer
+	// than pushBatchSize of them.
+	pushBatchBytes = 16 << 20
+	// maxPrintedPushErrors is the number of errors listed when a push fails.
+	maxPrintedPushErrors = 10
+)
+
+type pendingNode struct {
+	filename string
+	node     format.Node
+}
+
+// pusher uploads the nodes passed to push in batches, by up to jobs concurrent workers. Nodes that
+// are already present in the remote are skipped, so that a failed push can be resumed by running it
+// again.
+type pusher struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu           sync.Mutex
+	pendingNodes []pendingNode
+	pendingBytes int
+	errs         errorList
+
+	batches  chan []pendingNode
+	workers  sync.WaitGroup
+	progress *progress
+}
+
+func newPusher(ctx context.Context, cancel context.CancelFunc, jobs int) *pusher {
+	if jobs < 1 {
+		jobs = 1
+	}
+	p := &pusher{
+		ctx:      ctx,
+		cancel:   cancel,
+		batches:  make(chan []pendingNode, jobs),
+		progress: newProgress(),
+	}
+	for i := 0; i < jobs; i++ {
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for batch := range p.batches {
+				p.upload(batch)
+			}
+		}()
+	}
+	return p
+}
+
+// push queues a node to be uploaded, and hands the queue to the workers once it is large enough. It
+// is safe for concurrent use, and fails once an upload has failed.
+func (p *pusher) push(filename string, node format.Node) error {
 	if filename == "" {
 		filename = "."
 	}
-	localHash := node.Cid()
-	if exists(localHash) {
-		marker := color.GreenString("✓")
-		fmt.Printf("%s %s %s\n", color.YellowString(localHash.String()), marker, filename)
-	} else {
-		marker := color.BlueString("↑")
-		fmt.Printf("%s %s %s\n", color.YellowString(localHash.String()), marker, filename)
-		_, err := nodeService.AddObject(context.Background(), node.RawData())
-		return err
+	p.progress.add(&p.progress.hashedObjects, &p.progress.hashedBytes, len(node.RawData()))
+	p.mu.Lock()
+	p.pendingNodes = append(p.pendingNodes, pendingNode{
+		filename: filename,
+		node:     node,
+	})
+	p.pendingBytes += len(node.RawData())
+	var batch []pendingNode
+	if len(p.pendingNodes) >= pushBatchSize || p.pendingBytes >= pushBatchBytes {
+		batch = p.pendingNodes
+		p.pendingNodes = nil
+		p.pendingBytes = 0
+	}
+	p.mu.Unlock()
+	if batch != nil {
+		select {
+		case p.batches <- batch:
+		case <-p.ctx.Done():
+		}
+	}
+	return p.ctx.Err()
+}
+
+// wait uploads the remaining nodes, waits for all the workers to finish, and returns all the errors
+// that occurred, including walkErr.
+func (p *pusher) wait(walkErr error) errorList {
+	if len(p.pendingNodes) > 0 && p.ctx.Err() == nil {
+		p.batches <- p.pendingNodes
+	}
+	close(p.batches)
+	p.workers.Wait()
+	p.progress.stop()
+
+	errs := p.errs
+	if l, ok := walkErr.(errorList); ok {
+		for _, err := range l {
+			// Files not hashed because an upload failed are not worth reporting.
+			if err != context.Canceled {
+				errs = append(errs, err)
+			}
+		}
+	} else if walkErr != nil && walkErr != context.Canceled {
+		errs = append(errs, walkErr)
+	}
+	return errs
+}
+
+// upload uploads the nodes of the batch that are not already present in the remote, using a single
+// request to check which ones are missing, and a single request to upload them. On failure, the
+// error is recorded and the push is aborted.
+func (p *pusher) upload(batch []pendingNode) {
+	if p.ctx.Err() != nil {
+		return
+	}
+	err := p.uploadBatch(batch)
+	// Errors caused by another upload failing first are not worth reporting.
+	if err != nil && p.ctx.Err() == nil {
+		p.mu.Lock()
+		p.errs = append(p.errs, err)
+		p.mu.Unlock()
+		p.cancel()
 	}
+}
+
+func (p *pusher) uploadBatch(batch []pendingNode) error {
+	hashes := []multihash.Multihash{}
+	for _, n := range batch {
+		hashes = append(hashes, n.node.Cid().Hash())
+	}
+	missing, err := nodeService.MissingObjects(p.ctx, hashes)
+	if err != nil {
+		return fmt.Errorf("could not check missing objects: %v", err)
+	}
+	missingSet := map[string]bool{}
+	for _, h := range missing {
+		missingSet[string(h)] = true
+	}
+
+	objects := [][]byte{}
+	objectHashes := []multihash.Multihash{}
+	var lines []string
+	for _, n := range batch {
+		localHash := n.node.Cid()
+		if missingSet[string(localHash.Hash())] {
+			marker := color.BlueString("↑")
+			lines = append(lines, fmt.Sprintf("%s %s %s", color.YellowString(localHash.String()), marker, n.filename))
+			objects = append(objects, n.node.RawData())
+			objectHashes = append(objectHashes, localHash.Hash())
+			// Do not upload the same object twice.
+			delete(missingSet, string(localHash.Hash()))
+		} else {
+			marker := color.GreenString("✓")
+			lines = append(lines, fmt.Sprintf("%s %s %s", color.YellowString(localHash.String()), marker, n.filename))
+			p.progress.add(&p.progress.skippedObjects, &p.progress.skippedBytes, len(n.node.RawData()))
+		}
+	}
+
+	if len(objects) > 0 {
+		hs, err := nodeService.AddObjects(p.ctx, objects)
+		if err != nil {
+			return fmt.Errorf("could not upload %d objects: %v", len(objects), err)
+		}
+		for i, h := range hs {
+			// The remote may not support the configured hash function, and use its own instead.
+			if !bytes.Equal(h, objectHashes[i]) {
+				return fmt.Errorf("remote computed hash %s for object %s; check that it supports the %s hash function", h.HexString(), objectHashes[i].HexString(), utils.HashName(utils.DefaultHashType))
+			}
+		}
+		for _, o := range objects {
+			p.progress.add(&p.progress.uploadedObjects, &p.progress.uploadedBytes, len(o))
+		}
+	}
+	p.progress.println(lines)
 	return nil
 }
 
-func exists(hash cid.Cid) bool {
-	_, err := nodeService.GetObject(context.Background(), hash.Hash())
-	if err == nodeservice.ErrNotFound {
-		return false
-	} else if err != nil {
-		log.Fatal(err)
+func (p *pusher) summary() string {
+	return p.progress.String()
+}
+
+func printPushErrors(errs errorList) {
+	log.Printf("push failed with %d errors:", len(errs))
+	for i, err := range errs {
+		if i == maxPrintedPushErrors {
+			log.Printf("  ... and %d more", len(errs)-i)
+			break
+		}
+		log.Printf("  %v", err)
 	}
-	return true
+	log.Printf("objects uploaded so far are skipped when pushing again")
 }
@@ -1,37 +1,86 @@
 package cmd
 
 import (
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/google/ent/datastore"
+	"github.com/google/ent/gc"
 	"github.com/google/ent/nodeservice"
 	"github.com/google/ent/objectstore"
 	"github.com/google/ent/tagstore"
 	"github.com/google/ent/utils"
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
 	ignore "github.com/sabhiram/go-gitignore"
 	"github.com/spf13/cobra"
 )
 
 var (
+	config      Config
+	remote      Remote
 	nodeService nodeservice.NodeService
 	tagStore    tagstore.TagStore
+	// repairs runs the read repairs of the current remote, if enabled, and is closed once the
+	// command completes, so that pending repairs are not dropped.
+	repairs *nodeservice.RepairQueue
 )
 
 type Config struct {
 	DefaultRemote string `toml:"default_remote"`
 	Remotes       map[string]Remote
+	// SigningKey is the path of the file containing the hex-encoded ed25519 seed used to sign tags,
+	// as created by `ent keygen`.
+	SigningKey string `toml:"signing_key"`
+	// TrustedKeys are the base64-encoded ed25519 public keys whose signatures on tags are trusted.
+	TrustedKeys []string `toml:"trusted_keys"`
+	// CacheDir is the directory where objects read from URL remotes are cached; by default, the ent
+	// directory under the user cache directory (e.g. ~/.cache/ent/objects on Linux).
+	CacheDir string `toml:"cache_dir"`
+	// CacheMaxBytes is the maximum size of the cache; by default, nodeservice.DefaultCacheMaxBytes.
+	CacheMaxBytes int64 `toml:"cache_max_bytes"`
+	// Hash is the hash function of new content, such as local files and directories: "sha2-256"
+	// (the default), "sha2-512" or "blake3". Existing content keeps the hash function of its CID.
+	Hash string
 }
 
 type Remote struct {
 	Path string
 	URL  string
+	// Token is sent as a bearer token to URL remotes.
+	Token string
+	// LogKey is the base64-encoded public key of the transparency log of tag updates of the remote.
+	LogKey string `toml:"log_key"`
+	// NoCache disables the local cache of objects read from URL remotes. On a multiplexed remote,
+	// which is cached as a whole, it disables the cache for all the remotes it combines.
+	NoCache bool `toml:"no_cache"`
+
+	// Remotes, instead of Path or URL, lists the names of other remotes to multiplex. Reads try them
+	// in order, and tags are stored in the first one.
+	Remotes []string
+	// WriteMode selects the remotes that objects are written to: "primary" (the first one, by
+	// default), "all", or "quorum".
+	WriteMode string `toml:"write_mode"`
+	// Quorum is the number of remotes that writes must succeed on in "quorum" mode.
+	Quorum int
+	// ReadRepair copies objects found on a remote to the remotes before it that do not have them.
+	ReadRepair bool `toml:"read_repair"`
+	// ReadMode selects how reads query the remotes: "sequential" (in order, by default), "parallel"
+	// (all at once), or "hedged" (the next one whenever the previous ones are slower than
+	// HedgeDelay). In the last two modes, remotes are queried fastest first.
+	ReadMode string `toml:"read_mode"`
+	// HedgeDelay is a duration such as "200ms"; by default, nodeservice.DefaultHedgeDelay.
+	HedgeDelay string `toml:"hedge_delay"`
 }
 
 type Plan struct {
@@ -39,8 +88,12 @@ type Plan struct {
 }
 
 type Override struct {
-	Path       string
-	From       string
+	Path string
+	From string
+	// Tag, instead of From, names a tag whose value must be signed by one of the trusted keys.
+	Tag string
+	// Executable makes the file at Path executable. Files within pulled directories get the modes
+	// recorded in the DAG instead.
 	Executable bool
 }
 
@@ -85,9 +138,24 @@ func parseIgnore(targetDir string) *ignore.GitIgnore {
 }
 
 func InitRemote(remote Remote) {
-	if remote.URL != "" {
+	initRemote(remote, true)
+}
+
+// initRemote is like InitRemote, but only caches objects read from URL remotes if cache is set.
+func initRemote(remote Remote, cache bool) {
+	if len(remote.Remotes) > 0 {
+		initMultiplexRemote(remote)
+	} else if remote.URL != "" {
 		nodeService = nodeservice.Remote{
 			APIURL: remote.URL,
+			Token:  remote.Token,
+		}
+		if cache && !remote.NoCache {
+			nodeService = newCache(nodeService)
+		}
+		tagStore = tagstore.Remote{
+			APIURL: remote.URL,
+			Token:  remote.Token,
 		}
 	} else if remote.Path != "" {
 		baseDir := remote.Path
@@ -122,6 +190,92 @@ func InitRemote(remote Remote) {
 	}
 }
 
+// initMultiplexRemote combines the remotes listed in r. The first of them also becomes the current
+// remote, for the commands that use its settings directly, such as `ent tags verify`.
+//
+// If any of them is a URL remote, the combined remote is cached as a whole, rather than each URL
+// remote separately, so that the cache does not hide which remotes actually have an object from
+// read repair, and its size limit applies once.
+func initMultiplexRemote(r Remote) {
+	writeMode, err := nodeservice.ParseWriteMode(r.WriteMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	readMode, err := nodeservice.ParseReadMode(r.ReadMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var hedgeDelay time.Duration
+	if r.HedgeDelay != "" {
+		hedgeDelay, err = time.ParseDuration(r.HedgeDelay)
+		if err != nil {
+			log.Fatalf("invalid hedge delay: %v", err)
+		}
+	}
+	var stats *nodeservice.LatencyStats
+	if readMode != nodeservice.ReadSequential {
+		stats = &nodeservice.LatencyStats{}
+	}
+	inner := []nodeservice.NodeService{}
+	var primaryTagStore tagstore.TagStore
+	cache := false
+	for i, name := range r.Remotes {
+		innerRemote, ok := config.Remotes[name]
+		if !ok {
+			log.Fatalf("Invalid remote name: %q", name)
+		}
+		if len(innerRemote.Remotes) > 0 {
+			log.Fatalf("remote %q cannot be multiplexed, since it is itself multiplexed", name)
+		}
+		initRemote(innerRemote, false)
+		inner = append(inner, nodeService)
+		if innerRemote.URL != "" && !innerRemote.NoCache {
+			cache = true
+		}
+		if i == 0 {
+			primaryTagStore = tagStore
+			remote = innerRemote
+		}
+	}
+	if r.ReadRepair {
+		repairs = nodeservice.NewRepairQueue(0, 0)
+	}
+	nodeService = nodeservice.Multiplex{
+		Inner:      inner,
+		WriteMode:  writeMode,
+		Quorum:     r.Quorum,
+		Repairs:    repairs,
+		ReadMode:   readMode,
+		HedgeDelay: hedgeDelay,
+		Stats:      stats,
+	}
+	if cache && !r.NoCache {
+		nodeService = newCache(nodeService)
+	}
+	tagStore = primaryTagStore
+}
+
+func newCache(inner nodeservice.NodeService) nodeservice.NodeService {
+	return &nodeservice.Cache{
+		Inner:    inner,
+		DirName:  cacheDir(),
+		MaxBytes: config.CacheMaxBytes,
+	}
+}
+
+// cacheDir returns the directory of the local cache of objects read from URL remotes, which is
+// shared by all of them since objects are addressed by hash.
+func cacheDir() string {
+	if config.CacheDir != "" {
+		return config.CacheDir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		log.Fatalf("could not find cache dir: %v", err)
+	}
+	return filepath.Join(dir, "ent", "objects")
+}
+
 var rootCmd = &cobra.Command{
 	Use: "ent",
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
@@ -135,23 +289,35 @@ var rootCmd = &cobra.Command{
 			log.Printf("could not read config: %v", err)
 			// Continue anyways.
 		}
-		config := Config{}
 		err = toml.Unmarshal(f, &config)
 		if err != nil {
 			log.Fatalf("could not parse config: %v", err)
 		}
 		// log.Printf("parsed config: %#v", config)
 
+		if config.Hash != "" {
+			utils.DefaultHashType, err = utils.ParseHashType(config.Hash)
+			if err != nil {
+				log.Fatalf("invalid hash: %v", err)
+			}
+		}
+
 		if remoteName == "" && config.DefaultRemote != "" {
 			remoteName = config.DefaultRemote
 		}
 
-		remote, ok := config.Remotes[remoteName]
+		var ok bool
+		remote, ok = config.Remotes[remoteName]
 		if !ok {
 			log.Fatalf("Invalid remote name: %q", remoteName)
 		}
 		InitRemote(remote)
 	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		if repairs != nil {
+			repairs.Close()
+		}
+	},
 }
 
 func Execute() {
@@ -161,75 +327,280 @@ func Execute() {
 }
 
 var (
-	remoteName string
-	tagName    string
+	remoteName       string
+	tagName          string
+	expectedTagValue string
+	pushJobs         int
+
+	exportOutput string
+
+	pullForce  bool
+	pullDryRun bool
+
+	gcDryRun      bool
+	gcGracePeriod time.Duration
+	gcHistory     time.Duration
+	gcPins        []string
+
+	serveAddr      string
+	serveDomain    string
+	serveTemplates string
+	serveTokens    string
+	serveLogKey    string
 )
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&remoteName, "remote", "", "")
 
 	pushCmd.Flags().StringVar(&tagName, "tag", "", "")
+	pushCmd.Flags().IntVarP(&pushJobs, "jobs", "j", 8, "number of files hashed and batches uploaded concurrently")
+	pushCmd.Flags().StringVar(&expectedTagValue, "expect", "", "only update the tag if it currently has this value; if empty, only create it if it does not exist")
+
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "-", "file to write the CAR file to, or - for stdout")
+
+	for _, c := range []*cobra.Command{pullCmd, makeCmd} {
+		c.Flags().BoolVar(&pullForce, "force", false, "overwrite and delete local files even if their contents are not in the remote")
+		c.Flags().BoolVar(&pullDryRun, "dry-run", false, "only report files that would be added, removed or modified")
+	}
+
+	gcCmd.Flags().BoolVar(&gcDryRun, "dry-run", false, "only report objects that would be deleted")
+	gcCmd.Flags().DurationVar(&gcGracePeriod, "grace-period", time.Hour, "keep objects written more recently than this")
+	gcCmd.Flags().DurationVar(&gcHistory, "history", gc.DefaultHistoryRetention, "keep the values that tags had within this long ago, so that they can be reverted; 0 keeps only the current values")
+	gcCmd.Flags().StringSliceVar(&gcPins, "pin", nil, "additional root to keep")
+
+	serveCmd.Flags().StringVar(&serveAddr, "addr", ":8080", "address to listen on")
+	serveCmd.Flags().StringVar(&serveDomain, "domain", "", "domain name under which to serve nodes and tags as websites; if empty, websites are not served")
+	serveCmd.Flags().StringVar(&serveTemplates, "templates", "", "directory containing the templates of the browse UI; if empty, the UI is disabled")
+	serveCmd.Flags().StringVar(&serveTokens, "tokens", "", "file listing the tokens accepted by the server and their scopes; if empty, all requests are allowed")
+	serveCmd.Flags().StringVar(&serveLogKey, "log-key", "", "file containing the key used to sign the tree heads of the transparency log of tag updates; if empty, the log is disabled")
 
 	rootCmd.AddCommand(catCmd)
 	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(gcCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(keygenCmd)
 	rootCmd.AddCommand(makeCmd)
 	rootCmd.AddCommand(pullCmd)
 	rootCmd.AddCommand(pushCmd)
+	rootCmd.AddCommand(serveCmd)
 	rootCmd.AddCommand(statusCmd)
 	rootCmd.AddCommand(tagsCmd)
+
+	tagsCmd.AddCommand(tagsLogCmd)
+	tagsCmd.AddCommand(tagsLsCmd)
+	tagsCmd.AddCommand(tagsRevertCmd)
+	tagsCmd.AddCommand(tagsRmCmd)
+	tagsCmd.AddCommand(tagsVerifyCmd)
 }
 
-func traverse(base string, relativeFilename string, i *ignore.GitIgnore, f func(string, format.Node) error) cid.Cid {
-	file, err := os.Open(path.Join(base, relativeFilename))
+// traverse hashes the file or directory at relativeFilename under base, invoking f on each node
+// bottom-up. Nodes get CIDs with the given prefix, usually utils.DefaultCidPrefix(). Symbolic links,
+// other than base itself, are not followed, but stored as raw nodes containing their target.
+func traverse(base string, relativeFilename string, i *ignore.GitIgnore, prefix cid.Prefix, f func(string, format.Node) error) cid.Cid {
+	hash, err := walk(base, relativeFilename, i, prefix, 1, f)
 	if err != nil {
 		log.Fatal(err)
 	}
+	return hash
+}
+
+// errorList holds errors that occurred independently of each other, e.g. for different files.
+type errorList []error
+
+func (l errorList) Error() string {
+	s := make([]string, len(l))
+	for i, err := range l {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "\n")
+}
 
-	fileInfo, err := file.Stat()
+// appendError appends err, if not nil, to errs, flattening errorLists.
+func appendError(errs errorList, err error) errorList {
+	if l, ok := err.(errorList); ok {
+		return append(errs, l...)
+	}
 	if err != nil {
-		log.Fatal(err)
+		return append(errs, err)
+	}
+	return errs
+}
+
+// walk is like traverse, but returns an error instead of exiting. Up to jobs files are read and
+// hashed concurrently, in which case f must be safe for concurrent use. Errors for different files
+// are all collected into an errorList, and errors returned by f are returned as they are.
+func walk(base string, relativeFilename string, i *ignore.GitIgnore, prefix cid.Prefix, jobs int, f func(string, format.Node) error) (cid.Cid, error) {
+	w := walker{
+		base:   base,
+		ignore: i,
+		prefix: prefix,
+		f:      f,
+	}
+	if jobs > 1 {
+		w.sem = make(chan struct{}, jobs)
+	}
+	return w.walk(relativeFilename)
+}
+
+type walker struct {
+	base   string
+	ignore *ignore.GitIgnore
+	prefix cid.Prefix
+	f      func(string, format.Node) error
+	// sem limits the number of files read concurrently; if nil, the walk is sequential.
+	sem chan struct{}
+}
+
+func (w walker) walk(relativeFilename string) (cid.Cid, error) {
+	fullPath := path.Join(w.base, relativeFilename)
+	var fileInfo os.FileInfo
+	var err error
+	if relativeFilename == "" {
+		fileInfo, err = os.Stat(fullPath)
+	} else {
+		fileInfo, err = os.Lstat(fullPath)
+	}
+	if err != nil {
+		return cid.Undef, err
 	}
 
-	if fileInfo.IsDir() {
-		files, err := file.Readdir(-1)
+	if fileInfo.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(fullPath)
+		if err != nil {
+			return cid.Undef, err
+		}
+		node, err := merkledag.NewRawNodeWPrefix([]byte(target), w.prefix)
 		if err != nil {
-			log.Fatal(err)
+			return cid.Undef, err
 		}
+		err = w.f(relativeFilename, node)
+		if err != nil {
+			return cid.Undef, err
+		}
+		return node.Cid(), nil
+	} else if fileInfo.IsDir() {
+		return w.walkDir(relativeFilename)
+	}
+	return w.walkFile(relativeFilename, fileInfo)
+}
 
-		node := utils.NewProtoNode()
-		for _, ff := range files {
-			newRelativeFilename := path.Join(relativeFilename, ff.Name())
-			if i.MatchesPath(newRelativeFilename) {
-				// Nothing
-			} else {
-				hash := traverse(base, newRelativeFilename, i, f)
-				utils.SetLink(node, ff.Name(), hash)
-			}
+// walkFile hashes the regular file at relativeFilename, whose info is fileInfo.
+func (w walker) walkFile(relativeFilename string, fileInfo os.FileInfo) (cid.Cid, error) {
+	fullPath := path.Join(w.base, relativeFilename)
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer file.Close()
+
+	if fileInfo.Size() > utils.ChunkSize {
+		return w.walkChunks(file, relativeFilename)
+	}
+	bytes, err := ioutil.ReadAll(file)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("could not read %q: %v", fullPath, err)
+	}
+	node, err := merkledag.NewRawNodeWPrefix(bytes, w.prefix)
+	if err != nil {
+		return cid.Undef, err
+	}
+	err = w.f(relativeFilename, node)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return node.Cid(), nil
+}
+
+func (w walker) walkDir(relativeFilename string) (cid.Cid, error) {
+	files, err := ioutil.ReadDir(path.Join(w.base, relativeFilename))
+	if err != nil {
+		return cid.Undef, err
+	}
+	var entries []os.FileInfo
+	for _, ff := range files {
+		if !w.ignore.MatchesPath(path.Join(relativeFilename, ff.Name())) {
+			entries = append(entries, ff)
 		}
+	}
 
-		err = f(relativeFilename, node)
+	hashes := make([]cid.Cid, len(entries))
+	errs := make([]error, len(entries))
+	var wg sync.WaitGroup
+	for i, ff := range entries {
+		p := path.Join(relativeFilename, ff.Name())
+		if w.sem == nil || !ff.Mode().IsRegular() {
+			// Subdirectories are walked by this goroutine, so that only files, which do not wait
+			// for other entries, hold a slot of sem.
+			hashes[i], errs[i] = w.walk(p)
+			continue
+		}
+		// The slot is acquired before starting the goroutine, so that at most len(sem) of them
+		// exist at any time.
+		w.sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, p string, ff os.FileInfo) {
+			defer wg.Done()
+			defer func() { <-w.sem }()
+			hashes[i], errs[i] = w.walkFile(p, ff)
+		}(i, p, ff)
+	}
+	wg.Wait()
+	var errList errorList
+	for _, err := range errs {
+		errList = appendError(errList, err)
+	}
+	if len(errList) > 0 {
+		return cid.Undef, errList
+	}
+
+	node := utils.NewProtoNode()
+	node.SetCidBuilder(w.prefix)
+	for i, ff := range entries {
+		utils.SetLink(node, ff.Name(), hashes[i])
+		err := utils.SetMetadata(node, ff.Name(), utils.NewMetadata(ff))
 		if err != nil {
-			log.Fatal(err)
+			return cid.Undef, err
 		}
+	}
+	err = w.f(relativeFilename, node)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return node.Cid(), nil
+}
 
-		return node.Cid()
-		// } else if fileInfo.Mode() == os.ModeSymlink {
-		// skip
-	} else {
-		bytes, err := ioutil.ReadAll(file)
+// walkChunks splits a large file into raw nodes of utils.ChunkSize bytes, linked from a chunked
+// file node. f is invoked on each chunk as soon as it is read, and then on the file node itself, so
+// that only a single chunk is held in memory at any time.
+func (w walker) walkChunks(file *os.File, relativeFilename string) (cid.Cid, error) {
+	node := utils.NewChunkedFileNode()
+	node.SetCidBuilder(w.prefix)
+	for {
+		buf := make([]byte, utils.ChunkSize)
+		n, err := io.ReadFull(file, buf)
+		if err == io.EOF {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return cid.Undef, fmt.Errorf("could not read %q: %v", file.Name(), err)
+		}
+		chunk, err := merkledag.NewRawNodeWPrefix(buf[:n], w.prefix)
 		if err != nil {
-			log.Fatal(err)
+			return cid.Undef, err
 		}
-		node, err := utils.ParseRawNode(bytes)
+		err = w.f(relativeFilename, chunk)
 		if err != nil {
-			log.Fatal(err)
+			return cid.Undef, err
 		}
-
-		err = f(relativeFilename, node)
+		err = utils.AddChunk(node, chunk.Cid(), n)
 		if err != nil {
-			log.Fatal(err)
+			return cid.Undef, err
 		}
+	}
 
-		return node.Cid()
+	err := w.f(relativeFilename, node)
+	if err != nil {
+		return cid.Undef, err
 	}
+	return node.Cid(), nil
 }
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/google/ent/nodeservice"
+	"github.com/google/ent/server"
+	"github.com/google/ent/translog"
+	"github.com/google/ent/utils"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:  "serve",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ds, ok := nodeService.(nodeservice.DataStore)
+		if !ok {
+			log.Fatal("serve is only supported for path remotes")
+		}
+		srv := &server.Server{
+			ObjectStore:  ds.Inner,
+			TagStore:     tagStore,
+			DomainName:   serveDomain,
+			TemplatesDir: serveTemplates,
+		}
+		if serveTokens != "" {
+			auth, err := server.ParseTokens(serveTokens)
+			if err != nil {
+				log.Fatalf("could not load tokens: %v", err)
+			}
+			srv.Auth = auth
+		}
+		if serveLogKey != "" {
+			key, err := utils.ReadPrivateKey(serveLogKey)
+			if err != nil {
+				log.Fatalf("could not load log key: %v", err)
+			}
+			srv.Log = &translog.Log{
+				Nodes: nodeService,
+				Tags:  tagStore,
+				Key:   key,
+			}
+		}
+		log.Printf("serving remote %q on %s", remoteName, serveAddr)
+		log.Fatal(http.ListenAndServe(serveAddr, srv.Handler()))
+	},
+}
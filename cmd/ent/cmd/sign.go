@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/google/ent/tagstore"
+	"github.com/google/ent/utils"
+	"github.com/spf13/cobra"
+)
+
+var keygenCmd = &cobra.Command{
+	Use:  "keygen [file]",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		filename := args[0]
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatalf("could not generate key: %v", err)
+		}
+		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			log.Fatalf("could not create key file: %v", err)
+		}
+		_, err = f.WriteString(hex.EncodeToString(private.Seed()) + "\n")
+		if err != nil {
+			log.Fatalf("could not write key file: %v", err)
+		}
+		err = f.Close()
+		if err != nil {
+			log.Fatalf("could not write key file: %v", err)
+		}
+		fmt.Printf("public key: %s\n", base64.StdEncoding.EncodeToString(public))
+	},
+}
+
+// loadSigningKey reads the private key configured via signing_key, or returns nil if there is none.
+func loadSigningKey() (ed25519.PrivateKey, error) {
+	if config.SigningKey == "" {
+		return nil, nil
+	}
+	key, err := utils.ReadPrivateKey(config.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not read signing key: %v", err)
+	}
+	return key, nil
+}
+
+// isTrustedKey returns whether the public key is listed in trusted_keys.
+func isTrustedKey(key []byte) bool {
+	for _, k := range config.TrustedKeys {
+		trusted, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			log.Printf("invalid trusted key %q: %v", k, err)
+			continue
+		}
+		if bytes.Equal(trusted, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyTag returns the CID or hash that a tag value points to and, if the value is signed, the
+// signed statement. It returns an error if the value is signed but the signature is invalid, the
+// key is not trusted, or its sequence number is lower than the one last seen for the tag on the
+// current remote; callers that require a signature must check that the statement is not nil.
+func verifyTag(name string, value []byte) (string, *tagstore.SignedTag, error) {
+	target, signed, err := tagstore.ParseValue(value)
+	if err != nil {
+		return "", nil, err
+	}
+	if signed == nil {
+		return target, nil, nil
+	}
+	err = signed.Verify(name)
+	if err != nil {
+		return target, signed, err
+	}
+	if !isTrustedKey(signed.PublicKey) {
+		return target, signed, fmt.Errorf("tag %q is signed by untrusted key %s", name, base64.StdEncoding.EncodeToString(signed.PublicKey))
+	}
+	err = checkSeq(name, signed.Seq)
+	if err != nil {
+		return target, signed, err
+	}
+	return target, signed, nil
+}
+
+// checkSeq returns an error if seq is lower than the sequence number last seen for the tag on the
+// current remote, which means that the remote is replaying an older signed value, and otherwise
+// records it as the last seen one.
+func checkSeq(name string, seq uint64) error {
+	seqs, err := readSeqs()
+	if err != nil {
+		return fmt.Errorf("could not read last seen sequence numbers: %v", err)
+	}
+	last := seqs[name]
+	if seq < last {
+		return fmt.Errorf("tag %q has seq %d, but seq %d was seen before", name, seq, last)
+	}
+	if seq == last {
+		return nil
+	}
+	seqs[name] = seq
+	err = writeSeqs(seqs)
+	if err != nil {
+		return fmt.Errorf("could not save last seen sequence numbers: %v", err)
+	}
+	return nil
+}
+
+// seqsFilename returns the file storing the last seen sequence number of each signed tag of the
+// current remote.
+func seqsFilename() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ent", "tags", remoteName+".json"), nil
+}
+
+func readSeqs() (map[string]uint64, error) {
+	filename, err := seqsFilename()
+	if err != nil {
+		return nil, err
+	}
+	f, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return map[string]uint64{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	seqs := map[string]uint64{}
+	err = json.Unmarshal(f, &seqs)
+	if err != nil {
+		return nil, err
+	}
+	return seqs, nil
+}
+
+func writeSeqs(seqs map[string]uint64) error {
+	filename, err := seqsFilename()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(seqs)
+	if err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// nextSeq returns the sequence number to use when signing a new value for a tag whose current
+// value is current, or nil if it does not exist.
+func nextSeq(current []byte) uint64 {
+	_, signed, err := tagstore.ParseValue(current)
+	if err != nil || signed == nil {
+		return 1
+	}
+	return signed.Seq + 1
+}
@@ -2,11 +2,13 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 
 	"github.com/fatih/color"
 	"github.com/google/ent/nodeservice"
+	"github.com/google/ent/utils"
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/spf13/cobra"
 )
@@ -20,7 +22,7 @@ var statusCmd = &cobra.Command{
 			target = args[0]
 		}
 		i := parseIgnore(target)
-		traverse(target, "", i, status)
+		traverse(target, "", i, utils.DefaultCidPrefix(), status)
 	},
 }
 
@@ -30,7 +32,7 @@ func status(filename string, node format.Node) error {
 	}
 	marker := color.RedString("*")
 	_, err := nodeService.GetObject(context.Background(), node.Cid().Hash())
-	if err == nodeservice.ErrNotFound {
+	if errors.Is(err, nodeservice.ErrNotFound) {
 		marker = color.RedString("*")
 	} else if err != nil {
 		log.Fatalf("could not fetch object: %v", err)
@@ -2,18 +2,49 @@ package cmd
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/fatih/color"
+	"github.com/google/ent/tagstore"
+	"github.com/google/ent/translog"
 	"github.com/spf13/cobra"
 )
 
 var tagsCmd = &cobra.Command{
-	Use:  "tags",
+	Use:  "tags [prefix]",
 	Args: cobra.MaximumNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		tags, err := tagStore.List(context.Background())
+	Run:  listTags,
+}
+
+// tagsLsCmd lists the tags whose name starts with the prefix, e.g. "team/app/" for the tags in the
+// team/app namespace.
+var tagsLsCmd = &cobra.Command{
+	Use:  "ls [prefix]",
+	Args: cobra.MaximumNArgs(1),
+	Run:  listTags,
+}
+
+// listTagsPageSize is the number of tags fetched at a time, so that large namespaces are printed
+// progressively.
+const listTagsPageSize = 100
+
+func listTags(cmd *cobra.Command, args []string) {
+	prefix := ""
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+	after := ""
+	for {
+		tags, err := tagStore.List(context.Background(), prefix, after, listTagsPageSize)
 		if err != nil {
 			log.Fatalf("could not list tags: %v", err)
 		}
@@ -22,7 +53,257 @@ var tagsCmd = &cobra.Command{
 			if err != nil {
 				log.Fatalf("could not list tags: %v", err)
 			}
-			fmt.Printf("%s %s\n", color.YellowString(string(tagValue)), tag)
+			target, signed, err := verifyTag(tag, tagValue)
+			if err != nil {
+				fmt.Printf("%s %s %s (%v)\n", color.YellowString(target), color.RedString("✗"), tag, err)
+			} else if signed != nil {
+				fmt.Printf("%s %s %s (seq %d)\n", color.YellowString(target), color.GreenString("✓"), tag, signed.Seq)
+			} else {
+				fmt.Printf("%s %s %s\n", color.YellowString(target), color.YellowString("?"), tag)
+			}
+		}
+		if len(tags) < listTagsPageSize {
+			return
+		}
+		after = tags[len(tags)-1]
+	}
+}
+
+// tagsLogCmd prints the updates to a tag, most recent first. Each entry is numbered, starting from 0
+// for the most recent one, so that it can be referenced by tagsRevertCmd.
+var tagsLogCmd = &cobra.Command{
+	Use:  "log [name]",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		history, err := tagStore.History(context.Background(), name)
+		if err != nil {
+			log.Fatalf("could not get tag history: %v", err)
+		}
+		for i := len(history) - 1; i >= 0; i-- {
+			e := history[i]
+			previous := "-"
+			if e.Previous != nil {
+				previous = tagTarget(e.Previous)
+			}
+			value := "-"
+			if e.Value != nil {
+				value = tagTarget(e.Value)
+			}
+			fmt.Printf("%d %s %s -> %s\n", len(history)-1-i, e.Time.Local().Format(time.RFC3339), color.YellowString(previous), color.YellowString(value))
+		}
+	},
+}
+
+// tagsRevertCmd sets a tag back to the value it had after the n-th most recent update, as numbered
+// by tagsLogCmd. The revert is itself recorded as a new update.
+var tagsRevertCmd = &cobra.Command{
+	Use:  "revert [name] [n]",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatalf("could not parse entry number: %v", err)
+		}
+		history, err := tagStore.History(context.Background(), name)
+		if err != nil {
+			log.Fatalf("could not get tag history: %v", err)
+		}
+		if n < 0 || n >= len(history) {
+			log.Fatalf("invalid entry number %d; tag %q has %d entries", n, name, len(history))
+		}
+		latest := history[len(history)-1]
+		target := history[len(history)-1-n]
+		if target.Value == nil {
+			log.Fatalf("tag %q was deleted by entry %d; use ent tags rm instead", name, n)
+		}
+		value := target.Value
+		targetValue, signed, err := tagstore.ParseValue(target.Value)
+		if err != nil {
+			log.Fatalf("could not parse tag value: %v", err)
+		}
+		if signed != nil {
+			// Signed values cannot be reused, since their sequence number would go backwards.
+			key, err := loadSigningKey()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if key == nil {
+				log.Fatalf("tag %q is signed; signing_key must be configured to revert it", name)
+			}
+			value, err = tagstore.Sign(key, name, targetValue, nextSeq(latest.Value), time.Now()).Marshal()
+			if err != nil {
+				log.Fatalf("could not sign tag: %v", err)
+			}
+		}
+		err = tagStore.CompareAndSet(context.Background(), name, latest.Value, value)
+		if err != nil {
+			log.Fatalf("could not revert tag: %v", err)
+		}
+		fmt.Printf("%s %s\n", color.YellowString(targetValue), name)
+	},
+}
+
+var tagsRmCmd = &cobra.Command{
+	Use:  "rm [name]",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		err := tagStore.Delete(context.Background(), name)
+		if err != nil {
+			log.Fatalf("could not delete tag %q: %v", name, err)
+		}
+	},
+}
+
+// tagsVerifyCmd checks that the current value of a tag has been recorded in the transparency log
+// of the remote, and that the log is consistent with the tree head seen the last time it was
+// checked, which is stored locally, so that a server cannot show different logs to different
+// clients, or rewrite its log, without being detected.
+var tagsVerifyCmd = &cobra.Command{
+	Use:  "verify [name]",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		name := args[0]
+		if remote.URL == "" {
+			log.Fatal("verify is only supported for URL remotes")
+		}
+		if remote.LogKey == "" {
+			log.Fatalf("log_key must be configured for remote %q", remoteName)
+		}
+		logKey, err := base64.StdEncoding.DecodeString(remote.LogKey)
+		if err != nil || len(logKey) != ed25519.PublicKeySize {
+			log.Fatalf("invalid log_key for remote %q", remoteName)
+		}
+		client := translog.Client{
+			APIURL: remote.URL,
+			Token:  remote.Token,
+		}
+
+		// The server does not serve tree heads while a value is being set and logged, so any tree
+		// head fetched afterwards includes it.
+		value, err := tagStore.Get(ctx, name)
+		if err != nil {
+			log.Fatalf("could not get tag: %v", err)
+		}
+		sth, err := client.TreeHead(ctx)
+		if err != nil {
+			log.Fatalf("could not get tree head: %v", err)
+		}
+		err = sth.Verify(logKey)
+		if err != nil {
+			log.Fatalf("could not verify tree head: %v", err)
+		}
+
+		leafHash, err := translog.Entry{Name: name, Value: string(value)}.LeafHash()
+		if err != nil {
+			log.Fatal(err)
+		}
+		inclusion, err := client.InclusionProof(ctx, leafHash, sth.TreeSize)
+		if err == translog.ErrNotFound {
+			log.Fatalf("tag value is not in the log")
+		} else if err != nil {
+			log.Fatalf("could not get inclusion proof: %v", err)
+		}
+		err = translog.VerifyInclusion(leafHash, inclusion.LeafIndex, sth.TreeSize, sth.RootHash, inclusion.Hashes)
+		if err != nil {
+			log.Fatalf("could not verify inclusion proof: %v", err)
+		}
+
+		previous, err := readTreeHead()
+		if err != nil {
+			log.Fatalf("could not read last tree head: %v", err)
+		}
+		if previous != nil {
+			if previous.TreeSize > sth.TreeSize {
+				log.Fatalf("log shrank from %d to %d entries since last check", previous.TreeSize, sth.TreeSize)
+			}
+			consistency, err := client.ConsistencyProof(ctx, previous.TreeSize, sth.TreeSize)
+			if err != nil {
+				log.Fatalf("could not get consistency proof: %v", err)
+			}
+			err = translog.VerifyConsistency(previous.TreeSize, previous.RootHash, sth.TreeSize, sth.RootHash, consistency.Hashes)
+			if err != nil {
+				log.Fatalf("log is not consistent with last check: %v", err)
+			}
+		}
+		err = writeTreeHead(sth)
+		if err != nil {
+			log.Fatalf("could not save tree head: %v", err)
+		}
+
+		target, signed, err := verifyTag(name, value)
+		if err != nil {
+			log.Fatalf("tag is logged, but: %v", err)
+		}
+		signature := "unsigned"
+		if signed != nil {
+			signature = fmt.Sprintf("signed, seq %d", signed.Seq)
 		}
+		fmt.Printf("%s %s %s (%s; entry %d of %d)\n", color.YellowString(target), color.GreenString("✓"), name, signature, inclusion.LeafIndex, sth.TreeSize)
 	},
 }
+
+// treeHeadFilename returns the file storing the last verified tree head of the log of the current
+// remote.
+func treeHeadFilename() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ent", "logs", remoteName+".json"), nil
+}
+
+// readTreeHead returns the last verified tree head of the log of the current remote, or nil if it
+// has never been verified.
+func readTreeHead() (*translog.SignedTreeHead, error) {
+	filename, err := treeHeadFilename()
+	if err != nil {
+		return nil, err
+	}
+	f, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var sth translog.SignedTreeHead
+	err = json.Unmarshal(f, &sth)
+	if err != nil {
+		return nil, err
+	}
+	return &sth, nil
+}
+
+func writeTreeHead(sth *translog.SignedTreeHead) error {
+	filename, err := treeHeadFilename()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(sth)
+	if err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// tagTarget returns the CID or hash that a tag value points to, or the raw value if it cannot be
+// parsed.
+func tagTarget(value []byte) string {
+	target, _, err := tagstore.ParseValue(value)
+	if err != nil {
+		return string(value)
+	}
+	return target
+}
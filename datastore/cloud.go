@@ -17,9 +17,15 @@ package datastore
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
 	"io/ioutil"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
 )
 
 // Cloud is an implementation of DataStore using a Google Cloud Storage bucket.
@@ -37,7 +43,9 @@ func (s Cloud) Set(ctx context.Context, name string, value []byte) error {
 
 func (s Cloud) Get(ctx context.Context, name string) ([]byte, error) {
 	rc, err := s.Client.Bucket(s.BucketName).Object(name).NewReader(ctx)
-	if err != nil {
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrNotFound
+	} else if err != nil {
 		return nil, err
 	}
 	defer rc.Close()
@@ -48,6 +56,72 @@ func (s Cloud) Get(ctx context.Context, name string) ([]byte, error) {
 	return body, nil
 }
 
+func (s Cloud) GetReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, err := s.Client.Bucket(s.BucketName).Object(name).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrNotFound
+	}
+	return rc, err
+}
+
+type cloudWriter struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	bucket    *storage.BucketHandle
+	temp      *storage.ObjectHandle
+	writer    *storage.Writer
+	committed bool
+}
+
+// NewWriter streams data to a temporary object, which is then copied to its final name on
+// commit.
+func (s Cloud) NewWriter(ctx context.Context) (Writer, error) {
+	suffix := make([]byte, 16)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return nil, err
+	}
+	bucket := s.Client.Bucket(s.BucketName)
+	temp := bucket.Object(tempPrefix + hex.EncodeToString(suffix))
+	// Cancelling the context is the only way to abort an upload without creating the object.
+	ctx, cancel := context.WithCancel(ctx)
+	return &cloudWriter{
+		ctx:    ctx,
+		cancel: cancel,
+		bucket: bucket,
+		temp:   temp,
+		writer: temp.NewWriter(ctx),
+	}, nil
+}
+
+func (w *cloudWriter) Write(p []byte) (int, error) {
+	return w.writer.Write(p)
+}
+
+func (w *cloudWriter) Commit(name string) error {
+	err := w.writer.Close()
+	if err != nil {
+		return err
+	}
+	_, err = w.bucket.Object(name).CopierFrom(w.temp).Run(w.ctx)
+	if err != nil {
+		w.temp.Delete(w.ctx)
+		return err
+	}
+	w.committed = true
+	return w.temp.Delete(w.ctx)
+}
+
+func (w *cloudWriter) Close() error {
+	defer w.cancel()
+	if w.committed {
+		return nil
+	}
+	w.cancel()
+	w.writer.Close() // Ignore errors, the upload was cancelled.
+	return nil
+}
+
 func (s Cloud) Has(ctx context.Context, name string) (bool, error) {
 	_, err := s.Client.Bucket(s.BucketName).Object(name).Attrs(ctx)
 	if err != nil {
@@ -59,3 +133,59 @@ func (s Cloud) Has(ctx context.Context, name string) (bool, error) {
 	}
 	return true, nil
 }
+
+func (s Cloud) Stat(ctx context.Context, name string) (Info, error) {
+	attrs, err := s.Client.Bucket(s.BucketName).Object(name).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return Info{}, ErrNotFound
+	} else if err != nil {
+		return Info{}, err
+	}
+	return Info{
+		Size:    attrs.Size,
+		ModTime: attrs.Updated,
+	}, nil
+}
+
+// Touch updates the metadata of the object, which also updates its modification time.
+func (s Cloud) Touch(ctx context.Context, name string) error {
+	_, err := s.Client.Bucket(s.BucketName).Object(name).Update(ctx, storage.ObjectAttrsToUpdate{
+		Metadata: map[string]string{
+			"touched": time.Now().UTC().Format(time.RFC3339),
+		},
+	})
+	if err == storage.ErrObjectNotExist {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s Cloud) Delete(ctx context.Context, name string) error {
+	err := s.Client.Bucket(s.BucketName).Object(name).Delete(ctx)
+	if err == storage.ErrObjectNotExist {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s Cloud) List(ctx context.Context, prefix string, after string, limit int) ([]string, error) {
+	it := s.Client.Bucket(s.BucketName).Objects(ctx, &storage.Query{
+		Prefix:      prefix,
+		StartOffset: after,
+	})
+	names := []string{}
+	for limit <= 0 || len(names) < limit {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		// StartOffset is inclusive.
+		if attrs.Name == after || strings.HasPrefix(attrs.Name, tempPrefix) {
+			continue
+		}
+		names = append(names, attrs.Name)
+	}
+	return names, nil
+}
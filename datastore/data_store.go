@@ -17,6 +17,14 @@ package datastore
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound = fmt.Errorf("not found")
 )
 
 // DataStore is an interface defining low-level operations for handling unstructured key/value
@@ -24,6 +32,56 @@ import (
 type DataStore interface {
 	Set(ctx context.Context, name string, value []byte) error
 	Get(ctx context.Context, name string) ([]byte, error)
-	// TODO: return size
+	// GetReader is like Get, but streams the value instead of reading it all in memory.
+	GetReader(ctx context.Context, name string) (io.ReadCloser, error)
+	// NewWriter returns a Writer that streams a new value into the store.
+	NewWriter(ctx context.Context) (Writer, error)
 	Has(ctx context.Context, name string) (bool, error)
+	// Stat returns metadata about the value stored under name, or ErrNotFound.
+	Stat(ctx context.Context, name string) (Info, error)
+	// Delete removes the value stored under name, or returns ErrNotFound.
+	Delete(ctx context.Context, name string) error
+	// Touch sets the modification time of the value stored under name to the current time, where
+	// the store records it, or returns ErrNotFound.
+	Touch(ctx context.Context, name string) error
+	// List returns, in lexicographic order, up to limit names starting with prefix and strictly
+	// greater than after. A limit <= 0 means no limit. To fetch the next page, pass the last
+	// returned name as after.
+	List(ctx context.Context, prefix string, after string, limit int) ([]string, error)
+}
+
+// Writer streams a value into a DataStore under a name that is only known once all the data has
+// been written, e.g. the hash of the value itself. Values are not visible until committed.
+type Writer interface {
+	io.Writer
+	// Commit makes the data written so far available under the given name.
+	Commit(name string) error
+	// Close discards the data if it was not committed. It is safe to call after Commit.
+	Close() error
+}
+
+// tempPrefix is used for names of values that have not been committed yet; these are never
+// returned by List.
+const tempPrefix = ".tmp-"
+
+// Info contains metadata about a single value in a DataStore.
+type Info struct {
+	Size int64
+	// ModTime is the time the value was last written, if known by the underlying store.
+	ModTime time.Time
+}
+
+// filterNames applies the List semantics to an already sorted slice of names.
+func filterNames(names []string, prefix string, after string, limit int) []string {
+	out := []string{}
+	for _, n := range names {
+		if !strings.HasPrefix(n, prefix) || strings.HasPrefix(n, tempPrefix) || n <= after {
+			continue
+		}
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+		out = append(out, n)
+	}
+	return out
 }
@@ -17,9 +17,13 @@ package datastore
 
 import (
 	"context"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
+	"strings"
+	"time"
 )
 
 // File is an implementation of DataStore using the local file system, rooted at the
@@ -33,7 +37,70 @@ func (s File) Set(ctx context.Context, name string, value []byte) error {
 }
 
 func (s File) Get(ctx context.Context, name string) ([]byte, error) {
-	return ioutil.ReadFile(path.Join(s.DirName, name))
+	b, err := ioutil.ReadFile(path.Join(s.DirName, name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+func (s File) GetReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	f, err := os.Open(path.Join(s.DirName, name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+type fileWriter struct {
+	file      *os.File
+	dirName   string
+	committed bool
+}
+
+// NewWriter streams data to a temporary file in DirName, which is then atomically renamed on
+// commit.
+func (s File) NewWriter(ctx context.Context) (Writer, error) {
+	f, err := ioutil.TempFile(s.DirName, tempPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{
+		file:    f,
+		dirName: s.DirName,
+	}, nil
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	return w.file.Write(p)
+}
+
+func (w *fileWriter) Commit(name string) error {
+	err := w.file.Close()
+	if err != nil {
+		return err
+	}
+	// Match the permissions of files created by Set.
+	err = os.Chmod(w.file.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(w.file.Name(), path.Join(w.dirName, name))
+	if err != nil {
+		return err
+	}
+	w.committed = true
+	return nil
+}
+
+func (w *fileWriter) Close() error {
+	if w.committed {
+		return nil
+	}
+	w.file.Close() // Ignore errors, it may already be closed.
+	return os.Remove(w.file.Name())
 }
 
 func (s File) Has(ctx context.Context, name string) (bool, error) {
@@ -47,3 +114,49 @@ func (s File) Has(ctx context.Context, name string) (bool, error) {
 	}
 	return true, nil
 }
+
+func (s File) Stat(ctx context.Context, name string) (Info, error) {
+	fi, err := os.Stat(path.Join(s.DirName, name))
+	if os.IsNotExist(err) {
+		return Info{}, ErrNotFound
+	} else if err != nil {
+		return Info{}, err
+	}
+	return Info{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	}, nil
+}
+
+func (s File) Touch(ctx context.Context, name string) error {
+	now := time.Now()
+	err := os.Chtimes(path.Join(s.DirName, name), now, now)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s File) Delete(ctx context.Context, name string) error {
+	err := os.Remove(path.Join(s.DirName, name))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// List only considers regular files directly under DirName.
+func (s File) List(ctx context.Context, prefix string, after string, limit int) ([]string, error) {
+	files, err := ioutil.ReadDir(s.DirName)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, file := range files {
+		if file.Mode().IsRegular() && !strings.HasPrefix(file.Name(), tempPrefix) {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	return filterNames(names, prefix, after, limit), nil
+}
@@ -1,8 +1,11 @@
 package datastore
 
 import (
+	"bytes"
 	"context"
-	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
 )
 
 type InMemory struct {
@@ -19,11 +22,74 @@ func (s InMemory) Get(ctx context.Context, name string) ([]byte, error) {
 	if ok {
 		return b, nil
 	} else {
-		return nil, fmt.Errorf("not found")
+		return nil, ErrNotFound
 	}
 }
 
+func (s InMemory) GetReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	b, err := s.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+type inMemoryWriter struct {
+	bytes.Buffer
+	store InMemory
+}
+
+func (s InMemory) NewWriter(ctx context.Context) (Writer, error) {
+	return &inMemoryWriter{
+		store: s,
+	}, nil
+}
+
+func (w *inMemoryWriter) Commit(name string) error {
+	w.store.Inner[name] = w.Bytes()
+	return nil
+}
+
+func (w *inMemoryWriter) Close() error {
+	return nil
+}
+
 func (s InMemory) Has(ctx context.Context, name string) (bool, error) {
 	_, ok := s.Inner[name]
 	return ok, nil
 }
+
+func (s InMemory) Stat(ctx context.Context, name string) (Info, error) {
+	b, ok := s.Inner[name]
+	if !ok {
+		return Info{}, ErrNotFound
+	}
+	return Info{
+		Size: int64(len(b)),
+	}, nil
+}
+
+// Touch only checks that the value exists, since modification times are not recorded.
+func (s InMemory) Touch(ctx context.Context, name string) error {
+	if _, ok := s.Inner[name]; !ok {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s InMemory) Delete(ctx context.Context, name string) error {
+	if _, ok := s.Inner[name]; !ok {
+		return ErrNotFound
+	}
+	delete(s.Inner, name)
+	return nil
+}
+
+func (s InMemory) List(ctx context.Context, prefix string, after string, limit int) ([]string, error) {
+	names := make([]string, 0, len(s.Inner))
+	for name := range s.Inner {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return filterNames(names, prefix, after, limit), nil
+}
@@ -0,0 +1,227 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gc implements mark-and-sweep garbage collection of objects that are not reachable from a
+// set of roots, usually the values of all tags plus an explicit set of pinned nodes.
+package gc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/ent/nodeservice"
+	"github.com/google/ent/objectstore"
+	"github.com/google/ent/tagstore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/multiformats/go-multihash"
+)
+
+// listPageSize is the number of objects listed at a time during the sweep phase.
+const listPageSize = 1000
+
+type Options struct {
+	Roots []cid.Cid
+	// GracePeriod protects objects written less than this long ago, which may be part of a DAG that
+	// is still being uploaded and is not yet reachable from any root.
+	GracePeriod time.Duration
+	// DryRun only reports what would be deleted.
+	DryRun bool
+}
+
+type SweptObject struct {
+	Hash multihash.Multihash
+	Size int64
+}
+
+type Report struct {
+	Reachable int
+	// Missing counts objects that are reachable but not present in the store.
+	Missing int
+	// Kept counts unreachable objects that were kept because of the grace period.
+	Kept       int
+	Swept      []SweptObject
+	SweptBytes int64
+}
+
+// Run marks all the objects reachable from the roots by following DAG-PB links through ns, and then
+// deletes from store all the other objects older than the grace period.
+//
+// If any reachable node cannot be fetched, other than because it is not present in store, Run
+// returns an error without deleting anything, since its descendants could not be marked.
+func Run(ctx context.Context, ns nodeservice.NodeService, store objectstore.Store, opts Options) (Report, error) {
+	report := Report{}
+	// Take the reference time before marking, so that objects written during the mark phase are
+	// always covered by the grace period.
+	start := time.Now()
+
+	marked, missing, err := mark(ctx, ns, store, opts.Roots)
+	if err != nil {
+		return report, fmt.Errorf("could not mark reachable objects: %v", err)
+	}
+	report.Reachable = len(marked)
+	report.Missing = missing
+
+	// Pages are listed from the underlying store, so that names that are not hashes, which are
+	// skipped, still advance the cursor.
+	after := ""
+	for {
+		names, err := store.Inner.List(ctx, "", after, listPageSize)
+		if err != nil {
+			return report, fmt.Errorf("could not list objects: %v", err)
+		}
+		if len(names) == 0 {
+			break
+		}
+		after = names[len(names)-1]
+
+		for _, name := range names {
+			h, err := multihash.FromHexString(name)
+			if err != nil {
+				continue
+			}
+			if marked[string(h)] {
+				continue
+			}
+			info, err := store.Stat(ctx, h)
+			if err != nil {
+				return report, fmt.Errorf("could not stat object %s: %v", h.HexString(), err)
+			}
+			if start.Sub(info.ModTime) < opts.GracePeriod {
+				report.Kept++
+				continue
+			}
+			if !opts.DryRun {
+				err = store.Delete(ctx, h)
+				if err != nil {
+					return report, fmt.Errorf("could not delete object %s: %v", h.HexString(), err)
+				}
+			}
+			report.Swept = append(report.Swept, SweptObject{
+				Hash: h,
+				Size: info.Size,
+			})
+			report.SweptBytes += info.Size
+		}
+	}
+	return report, nil
+}
+
+// mark walks the DAGs from the roots breadth first, fetching each level concurrently. It returns
+// the set of reachable hashes, and the number of those that are missing from store.
+func mark(ctx context.Context, ns nodeservice.NodeService, store objectstore.Store, roots []cid.Cid) (map[string]bool, int, error) {
+	marked := map[string]bool{}
+	missing := 0
+	frontier := []cid.Cid{}
+	for _, root := range roots {
+		if !marked[string(root.Hash())] {
+			marked[string(root.Hash())] = true
+			frontier = append(frontier, root)
+		}
+	}
+
+	for len(frontier) > 0 {
+		fetched := map[string]bool{}
+		next := []cid.Cid{}
+		for o := range ns.GetMany(ctx, frontier) {
+			if o.Err != nil {
+				// Checked below, since errors do not indicate which node they refer to.
+				continue
+			}
+			fetched[string(o.Node.Cid().Hash())] = true
+			if _, ok := o.Node.(*merkledag.ProtoNode); !ok {
+				continue
+			}
+			for _, l := range o.Node.Links() {
+				if !marked[string(l.Cid.Hash())] {
+					marked[string(l.Cid.Hash())] = true
+					next = append(next, l.Cid)
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		for _, c := range frontier {
+			if fetched[string(c.Hash())] {
+				continue
+			}
+			ok, err := store.Has(ctx, c.Hash())
+			if err != nil {
+				return nil, 0, err
+			}
+			if ok {
+				return nil, 0, fmt.Errorf("could not fetch node %s", c)
+			}
+			missing++
+		}
+		frontier = next
+	}
+	return marked, missing, nil
+}
+
+// DefaultHistoryRetention is how long the previous values of tags are kept by default, so that
+// `ent tags revert` can restore them.
+const DefaultHistoryRetention = 30 * 24 * time.Hour
+
+// TagRoots returns the roots pointed to by all the tags in the store, plus those pointed to by the
+// given hidden tags, which are not listed by the store, if they exist. The roots that listed tags
+// pointed to at any time within the last history are also included, so that tags can be reverted
+// to them.
+func TagRoots(ctx context.Context, ns nodeservice.NodeService, tags tagstore.TagStore, hidden []string, history time.Duration) ([]cid.Cid, error) {
+	names, err := tags.List(ctx, "", "", 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not list tags: %v", err)
+	}
+	roots := []cid.Cid{}
+	for _, name := range append(names, hidden...) {
+		value, err := tags.Get(ctx, name)
+		if err == tagstore.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("could not get tag %q: %v", name, err)
+		}
+		root, err := tagstore.ParseRoot(ctx, ns, value)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse tag %q: %v", name, err)
+		}
+		roots = append(roots, root)
+	}
+	if history <= 0 {
+		return roots, nil
+	}
+	since := time.Now().Add(-history)
+	for _, name := range names {
+		entries, err := tags.History(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("could not get history of tag %q: %v", name, err)
+		}
+		for _, e := range entries {
+			// The value replaced by an update was the current one until then.
+			if e.Time.Before(since) || e.Previous == nil {
+				continue
+			}
+			root, err := tagstore.ParseRoot(ctx, ns, e.Previous)
+			if err != nil {
+				// The object that a hash points to may already have been deleted, in which case
+				// there is nothing left to keep.
+				continue
+			}
+			roots = append(roots, root)
+		}
+	}
+	return roots, nil
+}
@@ -0,0 +1,231 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/ent/datastore"
+	"github.com/google/ent/nodeservice"
+	"github.com/google/ent/objectstore"
+	"github.com/google/ent/tagstore"
+	"github.com/google/ent/utils"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/multiformats/go-multihash"
+)
+
+type testStore struct {
+	t     *testing.T
+	dir   string
+	store objectstore.Store
+	ns    nodeservice.DataStore
+	tags  tagstore.File
+}
+
+func newTestStore(t *testing.T) *testStore {
+	dir := t.TempDir()
+	store := objectstore.Store{
+		Inner: datastore.File{
+			DirName: dir,
+		},
+	}
+	return &testStore{
+		t:     t,
+		dir:   dir,
+		store: store,
+		ns:    nodeservice.DataStore{Inner: store},
+		tags:  tagstore.File{DirName: t.TempDir()},
+	}
+}
+
+func (s *testStore) add(node format.Node) cid.Cid {
+	err := s.ns.Add(context.Background(), node)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return node.Cid()
+}
+
+func (s *testStore) addFile(content string) cid.Cid {
+	node, err := utils.ParseRawNode([]byte(content))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return s.add(node)
+}
+
+func (s *testStore) addDir(entries map[string]cid.Cid) cid.Cid {
+	node := utils.NewProtoNode()
+	for name, c := range entries {
+		err := utils.SetLink(node, name, c)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	return s.add(node)
+}
+
+func (s *testStore) setTag(name string, c cid.Cid) {
+	err := s.tags.Set(context.Background(), name, []byte(c.String()))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// age makes all the objects in the store look as if they had been written d ago.
+func (s *testStore) age(d time.Duration) {
+	names, err := s.store.Inner.List(context.Background(), "", "", 0)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	old := time.Now().Add(-d)
+	for _, name := range names {
+		err := os.Chtimes(filepath.Join(s.dir, name), old, old)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+	}
+}
+
+func (s *testStore) has(c cid.Cid) bool {
+	ok, err := s.store.Has(context.Background(), c.Hash())
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return ok
+}
+
+func (s *testStore) run(pins []cid.Cid, history time.Duration, dryRun bool) Report {
+	ctx := context.Background()
+	roots, err := TagRoots(ctx, s.ns, s.tags, nil, history)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	report, err := Run(ctx, s.ns, s.store, Options{
+		Roots:       append(roots, pins...),
+		GracePeriod: time.Hour,
+		DryRun:      dryRun,
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return report
+}
+
+func sweptSet(report Report) map[string]bool {
+	swept := map[string]bool{}
+	for _, o := range report.Swept {
+		swept[string(o.Hash)] = true
+	}
+	return swept
+}
+
+func TestRun(t *testing.T) {
+	for _, history := range []time.Duration{0, DefaultHistoryRetention} {
+		t.Run(fmt.Sprintf("history=%v", history), func(t *testing.T) {
+			s := newTestStore(t)
+			a := s.addFile("a")
+			b := s.addFile("b")
+			shared := s.addFile("shared")
+			previous := s.addDir(map[string]cid.Cid{"a": a, "shared": shared})
+			previousOnly := s.addFile("previous only")
+			previousRoot := s.addDir(map[string]cid.Cid{"dir": previous, "file": previousOnly})
+			current := s.addDir(map[string]cid.Cid{"b": b, "shared": shared})
+			pinned := s.addDir(map[string]cid.Cid{"pinned": s.addFile("pinned")})
+			unreachable := s.addFile("unreachable")
+			s.setTag("app", previousRoot)
+			s.setTag("app", current)
+			s.age(2 * time.Hour)
+			recent := s.addFile("recent")
+
+			reachable := []cid.Cid{current, b, shared, pinned}
+			fromHistory := []cid.Cid{previousRoot, previous, previousOnly, a}
+			wantSwept := []cid.Cid{unreachable}
+			if history > 0 {
+				reachable = append(reachable, fromHistory...)
+			} else {
+				wantSwept = append(wantSwept, fromHistory...)
+			}
+
+			for _, dryRun := range []bool{true, false} {
+				report := s.run([]cid.Cid{pinned}, history, dryRun)
+				swept := sweptSet(report)
+				if len(report.Swept) != len(wantSwept) {
+					t.Errorf("dry run %v: swept %d objects, want %d", dryRun, len(report.Swept), len(wantSwept))
+				}
+				for _, c := range wantSwept {
+					if !swept[string(c.Hash())] {
+						t.Errorf("dry run %v: %s was not swept", dryRun, c)
+					}
+					if s.has(c) == !dryRun {
+						t.Errorf("dry run %v: %s present: %v", dryRun, c, s.has(c))
+					}
+				}
+				for _, c := range append(reachable, recent) {
+					if swept[string(c.Hash())] || !s.has(c) {
+						t.Errorf("dry run %v: %s was swept", dryRun, c)
+					}
+				}
+				if report.Kept != 1 {
+					t.Errorf("dry run %v: kept %d objects for the grace period, want 1", dryRun, report.Kept)
+				}
+			}
+		})
+	}
+}
+
+// TestRunTouched checks that objects reported as present to a writer, which may then make them
+// reachable again without writing them, are protected by the grace period.
+func TestRunTouched(t *testing.T) {
+	s := newTestStore(t)
+	old := s.addFile("old")
+	s.age(2 * time.Hour)
+	missing, err := s.ns.MissingObjects(context.Background(), []multihash.Multihash{old.Hash()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 0 {
+		t.Fatalf("MissingObjects returned %v, want none", missing)
+	}
+	report := s.run(nil, 0, false)
+	if len(report.Swept) != 0 || !s.has(old) {
+		t.Errorf("touched object was swept")
+	}
+}
+
+// TestRunSkipsInvalidNames checks that the sweep goes past pages made only of names that are not
+// hashes.
+func TestRunSkipsInvalidNames(t *testing.T) {
+	s := newTestStore(t)
+	unreachable := s.addFile("unreachable")
+	for i := 0; i <= listPageSize; i++ {
+		err := s.store.Inner.Set(context.Background(), fmt.Sprintf(".junk-%04d", i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.age(2 * time.Hour)
+	report := s.run(nil, 0, false)
+	if len(report.Swept) != 1 || s.has(unreachable) {
+		t.Errorf("unreachable object was not swept: %+v", report)
+	}
+}
@@ -0,0 +1,370 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodeservice
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/ent/datastore"
+	"github.com/google/ent/objectstore"
+	"github.com/google/ent/utils"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/multiformats/go-multihash"
+)
+
+// DefaultCacheMaxBytes is the maximum total size of the objects in a Cache, unless specified.
+const DefaultCacheMaxBytes = 1 << 30
+
+// Cache is a NodeService that keeps a copy of the objects read from Inner in a directory on the
+// local file system, and serves subsequent reads of the same objects from there. Objects are
+// immutable and addressed by their hash, so cached copies never need to be invalidated; they are
+// verified before being cached, and again when read from the cache.
+//
+// Once the total size of the cached objects exceeds MaxBytes, the least recently used ones are
+// evicted. The directory may be shared by several processes: each tracks recency and size in
+// memory, starting from the modification times of the files, which are updated on every read, so
+// the limit is only approximately enforced.
+//
+// Writes, Has and MissingObjects always go to Inner, since the cache does not reflect which objects
+// are present there.
+type Cache struct {
+	Inner NodeService
+	// DirName is the directory in which cached objects are stored, one file per object.
+	DirName string
+	// MaxBytes is the maximum total size of the cached objects; if zero, DefaultCacheMaxBytes is used.
+	MaxBytes int64
+
+	once sync.Once
+	mu   sync.Mutex
+	// lru lists the hex-encoded hashes of the cached objects, most recently used first.
+	lru     *list.List
+	entries map[string]*list.Element
+	sizes   map[string]int64
+	size    int64
+}
+
+func (s *Cache) store() objectstore.Store {
+	return objectstore.Store{
+		Inner: datastore.File{
+			DirName: s.DirName,
+		},
+	}
+}
+
+func (s *Cache) maxBytes() int64 {
+	if s.MaxBytes == 0 {
+		return DefaultCacheMaxBytes
+	}
+	return s.MaxBytes
+}
+
+// init loads the existing objects in the directory, least recently used first. Errors are ignored,
+// and only result in an empty cache.
+func (s *Cache) init() {
+	s.lru = list.New()
+	s.entries = map[string]*list.Element{}
+	s.sizes = map[string]int64{}
+	os.MkdirAll(s.DirName, 0755)
+	files, _ := ioutil.ReadDir(s.DirName)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, f := range files {
+		if !f.Mode().IsRegular() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		s.record(f.Name(), f.Size())
+	}
+	s.evict()
+}
+
+// record marks the object as the most recently used one. It must be called while holding mu.
+func (s *Cache) record(name string, size int64) {
+	if e, ok := s.entries[name]; ok {
+		s.lru.MoveToFront(e)
+		return
+	}
+	s.entries[name] = s.lru.PushFront(name)
+	s.sizes[name] = size
+	s.size += size
+}
+
+// forget removes the object from the index. It must be called while holding mu.
+func (s *Cache) forget(name string) {
+	if e, ok := s.entries[name]; ok {
+		s.lru.Remove(e)
+		delete(s.entries, name)
+		s.size -= s.sizes[name]
+		delete(s.sizes, name)
+	}
+}
+
+// evict deletes the least recently used objects until the cache fits in MaxBytes. It must be called
+// while holding mu.
+func (s *Cache) evict() {
+	for s.size > s.maxBytes() && s.lru.Len() > 0 {
+		name := s.lru.Back().Value.(string)
+		s.forget(name)
+		os.Remove(filepath.Join(s.DirName, name))
+	}
+}
+
+// touch records a read of the object from the cache, and updates the modification time of its file
+// so that other processes see it as recently used.
+func (s *Cache) touch(h multihash.Multihash, size int64) {
+	name := h.HexString()
+	s.mu.Lock()
+	s.record(name, size)
+	s.mu.Unlock()
+	now := time.Now()
+	os.Chtimes(filepath.Join(s.DirName, name), now, now)
+}
+
+// added records an object newly written to the cache, evicting others if necessary.
+func (s *Cache) added(h multihash.Multihash, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record(h.HexString(), size)
+	s.evict()
+}
+
+// remove deletes a corrupted or otherwise unreadable object from the cache.
+func (s *Cache) remove(h multihash.Multihash) {
+	name := h.HexString()
+	s.mu.Lock()
+	s.forget(name)
+	s.mu.Unlock()
+	os.Remove(filepath.Join(s.DirName, name))
+}
+
+func (s *Cache) GetObject(ctx context.Context, h multihash.Multihash) ([]byte, error) {
+	s.once.Do(s.init)
+	b, err := s.store().Get(ctx, h)
+	if err == nil {
+		s.touch(h, int64(len(b)))
+		return b, nil
+	} else if err != datastore.ErrNotFound {
+		s.remove(h)
+	}
+	b, err = s.Inner.GetObject(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	// Caching is best effort; the content is hashed with the hash function of h, and only cached if
+	// it matches.
+	if decoded, err := multihash.Decode(h); err == nil {
+		if added, err := s.store().AddWithHashType(ctx, decoded.Code, b); err == nil && string(added) == string(h) {
+			s.added(h, int64(len(b)))
+		}
+	}
+	return b, nil
+}
+
+// GetObjectReader reads the object from the cache if present, verifying it before returning it, so
+// that a corrupted copy can still be replaced by fetching the object from Inner. Otherwise it
+// streams the object from Inner, while writing it to the cache, where it is only committed once it
+// has been fully read and verified.
+func (s *Cache) GetObjectReader(ctx context.Context, h multihash.Multihash) (io.ReadCloser, error) {
+	s.once.Do(s.init)
+	b, err := s.store().Get(ctx, h)
+	if err == nil {
+		s.touch(h, int64(len(b)))
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	} else if err != datastore.ErrNotFound {
+		s.remove(h)
+	}
+	rc, err := s.Inner.GetObjectReader(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	vr, err := objectstore.NewVerifyingReader(rc, h)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	w, err := datastore.File{DirName: s.DirName}.NewWriter(ctx)
+	if err != nil {
+		// Stream without caching.
+		return vr, nil
+	}
+	return &cachingReader{
+		inner: vr,
+		w:     w,
+		h:     h,
+		cache: s,
+	}, nil
+}
+
+// cachingReader copies everything read from inner to w, and commits it to the cache once inner
+// reaches EOF, which means that the content has been verified.
+type cachingReader struct {
+	inner io.ReadCloser
+	// w is nil once the object has been committed, or if writing to the cache failed.
+	w     datastore.Writer
+	size  int64
+	h     multihash.Multihash
+	cache *Cache
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.inner.Read(p)
+	if r.w != nil {
+		if _, werr := r.w.Write(p[:n]); werr != nil {
+			r.w.Close()
+			r.w = nil
+		}
+		r.size += int64(n)
+	}
+	if err == io.EOF && r.w != nil {
+		if r.w.Commit(r.h.HexString()) == nil {
+			r.cache.added(r.h, r.size)
+		}
+		r.w.Close()
+		r.w = nil
+	}
+	return n, err
+}
+
+// Close discards the partially written copy if the object was not fully read.
+func (r *cachingReader) Close() error {
+	if r.w != nil {
+		r.w.Close()
+		r.w = nil
+	}
+	return r.inner.Close()
+}
+
+func (s *Cache) AddObject(ctx context.Context, b []byte) (multihash.Multihash, error) {
+	return s.Inner.AddObject(ctx, b)
+}
+
+func (s *Cache) PutObject(ctx context.Context, r io.Reader) (multihash.Multihash, error) {
+	return s.Inner.PutObject(ctx, r)
+}
+
+func (s *Cache) MissingObjects(ctx context.Context, hs []multihash.Multihash) ([]multihash.Multihash, error) {
+	return s.Inner.MissingObjects(ctx, hs)
+}
+
+func (s *Cache) AddObjects(ctx context.Context, bs [][]byte) ([]multihash.Multihash, error) {
+	return s.Inner.AddObjects(ctx, bs)
+}
+
+func (s *Cache) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	return s.Inner.Has(ctx, c)
+}
+
+// cachedNode returns the node if it is in the cache.
+func (s *Cache) cachedNode(ctx context.Context, c cid.Cid) (format.Node, bool) {
+	s.once.Do(s.init)
+	b, err := s.store().Get(ctx, c.Hash())
+	if err == datastore.ErrNotFound {
+		return nil, false
+	} else if err != nil {
+		s.remove(c.Hash())
+		return nil, false
+	}
+	node, err := utils.ParseNodeFromBytes(c, b)
+	if err != nil {
+		return nil, false
+	}
+	s.touch(c.Hash(), int64(len(b)))
+	return node, true
+}
+
+// addNode caches a node read from Inner, which has already verified it.
+func (s *Cache) addNode(ctx context.Context, node format.Node) {
+	b := node.RawData()
+	hashType := node.Cid().Prefix().MhType
+	if added, err := s.store().AddWithHashType(ctx, hashType, b); err == nil && string(added) == string(node.Cid().Hash()) {
+		s.added(added, int64(len(b)))
+	}
+}
+
+func (s *Cache) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+	if node, ok := s.cachedNode(ctx, c); ok {
+		return node, nil
+	}
+	node, err := s.Inner.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	s.addNode(ctx, node)
+	return node, nil
+}
+
+// GetMany returns the cached nodes first, and fetches the others with a single call to
+// Inner.GetMany, so that they can be batched.
+func (s *Cache) GetMany(ctx context.Context, cc []cid.Cid) <-chan *format.NodeOption {
+	out := make(chan *format.NodeOption, len(cc)+1)
+	go func() {
+		defer close(out)
+		missing := []cid.Cid{}
+		for _, c := range cc {
+			if node, ok := s.cachedNode(ctx, c); ok {
+				out <- &format.NodeOption{Node: node}
+			} else {
+				missing = append(missing, c)
+			}
+		}
+		if len(missing) == 0 {
+			return
+		}
+		for o := range s.Inner.GetMany(ctx, missing) {
+			if o.Err == nil {
+				s.addNode(ctx, o.Node)
+			}
+			out <- o
+		}
+	}()
+	return out
+}
+
+func (s *Cache) Add(ctx context.Context, node format.Node) error {
+	return s.Inner.Add(ctx, node)
+}
+
+func (s *Cache) AddMany(ctx context.Context, nodes []format.Node) error {
+	return s.Inner.AddMany(ctx, nodes)
+}
+
+// Remove removes the node from Inner, and also from the cache.
+func (s *Cache) Remove(ctx context.Context, c cid.Cid) error {
+	s.once.Do(s.init)
+	s.remove(c.Hash())
+	return s.Inner.Remove(ctx, c)
+}
+
+func (s *Cache) RemoveMany(ctx context.Context, cc []cid.Cid) error {
+	for _, c := range cc {
+		err := s.Remove(ctx, c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
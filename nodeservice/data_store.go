@@ -17,8 +17,9 @@ package nodeservice
 
 import (
 	"context"
-	"fmt"
+	"io"
 
+	"github.com/google/ent/datastore"
 	"github.com/google/ent/objectstore"
 	"github.com/google/ent/utils"
 	"github.com/ipfs/go-cid"
@@ -26,8 +27,6 @@ import (
 	"github.com/multiformats/go-multihash"
 )
 
-const hashType = multihash.SHA2_256
-
 type DataStore struct {
 	Inner objectstore.Store
 }
@@ -40,6 +39,42 @@ func (s DataStore) AddObject(ctx context.Context, b []byte) (multihash.Multihash
 	return s.Inner.Add(ctx, b)
 }
 
+func (s DataStore) GetObjectReader(ctx context.Context, h multihash.Multihash) (io.ReadCloser, error) {
+	return s.Inner.GetReader(ctx, h)
+}
+
+func (s DataStore) PutObject(ctx context.Context, r io.Reader) (multihash.Multihash, error) {
+	return s.Inner.Put(ctx, r)
+}
+
+// MissingObjects also touches the objects that are present, since callers then skip writing them
+// and may make them reachable again; this restarts the grace period of garbage collection, which
+// could otherwise delete them before they are.
+func (s DataStore) MissingObjects(ctx context.Context, hs []multihash.Multihash) ([]multihash.Multihash, error) {
+	missing := []multihash.Multihash{}
+	for _, h := range hs {
+		err := s.Inner.Touch(ctx, h)
+		if err == datastore.ErrNotFound {
+			missing = append(missing, h)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+func (s DataStore) AddObjects(ctx context.Context, bs [][]byte) ([]multihash.Multihash, error) {
+	hs := []multihash.Multihash{}
+	for _, b := range bs {
+		h, err := s.Inner.Add(ctx, b)
+		if err != nil {
+			return nil, err
+		}
+		hs = append(hs, h)
+	}
+	return hs, nil
+}
+
 func (s DataStore) Has(ctx context.Context, c cid.Cid) (bool, error) {
 	_, err := s.Inner.Get(ctx, c.Hash())
 	return err == nil, nil
@@ -50,33 +85,35 @@ func (s DataStore) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
 	if err != nil {
 		return nil, err
 	}
-	switch c.Prefix().Codec {
-	case cid.DagProtobuf:
-		return utils.ParseProtoNode(bytes)
-	case cid.Raw:
-		return utils.ParseRawNode(bytes)
-	default:
-		return nil, fmt.Errorf("invalid codec")
-	}
+	return utils.ParseNodeFromBytes(c, bytes)
 }
 
 func (s DataStore) GetMany(ctx context.Context, cc []cid.Cid) <-chan *format.NodeOption {
-	return nil
+	return getMany(ctx, s.Get, cc)
 }
 
 func (s DataStore) Add(ctx context.Context, node format.Node) error {
-	_, err := s.Inner.Add(ctx, node.RawData())
+	// The object is stored under the hash of the node's CID, which may not use the default hash
+	// function.
+	_, err := s.Inner.AddWithHashType(ctx, node.Cid().Prefix().MhType, node.RawData())
 	return err
 }
 
 func (s DataStore) AddMany(ctx context.Context, nodes []format.Node) error {
-	return fmt.Errorf("not implemented")
+	return addMany(ctx, s.Add, nodes)
 }
 
+// Remove deletes the object underlying the node, regardless of whether other nodes link to it.
 func (s DataStore) Remove(ctx context.Context, c cid.Cid) error {
-	return fmt.Errorf("not implemented")
+	return s.Inner.Delete(ctx, c.Hash())
 }
 
 func (s DataStore) RemoveMany(ctx context.Context, cc []cid.Cid) error {
-	return fmt.Errorf("not implemented")
+	for _, c := range cc {
+		err := s.Remove(ctx, c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
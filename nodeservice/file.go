@@ -0,0 +1,86 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodeservice
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/google/ent/utils"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// NewFileReader returns a reader over the content of the file identified by c, which may be either
+// a raw node or a chunked file node. Chunks are fetched lazily and verified as they are read.
+func NewFileReader(ctx context.Context, s NodeService, c cid.Cid) (io.ReadCloser, error) {
+	if c.Prefix().Codec == cid.Raw {
+		return s.GetObjectReader(ctx, c.Hash())
+	}
+	node, err := s.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if !utils.IsChunkedFile(node) {
+		return nil, fmt.Errorf("not a file: %s", c)
+	}
+	return &chunkedFileReader{
+		ctx:   ctx,
+		s:     s,
+		links: node.Links(),
+	}, nil
+}
+
+type chunkedFileReader struct {
+	ctx     context.Context
+	s       NodeService
+	links   []*format.Link
+	current io.ReadCloser
+}
+
+func (r *chunkedFileReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.links) == 0 {
+				return 0, io.EOF
+			}
+			next, err := r.s.GetObjectReader(r.ctx, r.links[0].Cid.Hash())
+			if err != nil {
+				return 0, fmt.Errorf("could not fetch chunk %s: %v", r.links[0].Cid, err)
+			}
+			r.current = next
+			r.links = r.links[1:]
+		}
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, err
+	}
+}
+
+func (r *chunkedFileReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodeservice
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWeight is the weight of each new sample in the moving average of the latency.
+const latencyWeight = 0.2
+
+// failureLatency is the latency recorded for requests that fail with an error other than
+// ErrNotFound, so that unreachable services are tried last.
+const failureLatency = 10 * time.Second
+
+// LatencyStats tracks the latency of each inner service of a Multiplex, identified by its index, as
+// an exponentially weighted moving average. It is safe for concurrent use.
+type LatencyStats struct {
+	mu        sync.Mutex
+	latencies map[int]time.Duration
+}
+
+// Record adds a sample for the request to the inner service i that took d and returned err.
+func (s *LatencyStats) Record(i int, d time.Duration, err error) {
+	if err != nil && !errors.Is(err, ErrNotFound) && d < failureLatency {
+		d = failureLatency
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.latencies == nil {
+		s.latencies = map[int]time.Duration{}
+	}
+	if l, ok := s.latencies[i]; ok {
+		s.latencies[i] = l + time.Duration(latencyWeight*float64(d-l))
+	} else {
+		s.latencies[i] = d
+	}
+}
+
+// Latency returns the average latency of the inner service i, if any request to it was recorded.
+func (s *LatencyStats) Latency(i int) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.latencies[i]
+	return l, ok
+}
+
+// Order returns the indices of n inner services, fastest first. Services without samples come
+// first, so that they get some, and ties keep their original order.
+func (s *LatencyStats) Order(n int) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return s.latencies[order[a]] < s.latencies[order[b]]
+	})
+	return order
+}
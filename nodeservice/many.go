@@ -0,0 +1,97 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodeservice
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// concurrency is the maximum number of operations issued in parallel by GetMany and AddMany.
+const concurrency = 16
+
+// getMany implements GetMany on top of a function fetching a single node, calling it in parallel
+// for up to concurrency nodes at a time. Results are sent in completion order, and the channel is
+// closed once all of them have been sent, or after an error if ctx is cancelled.
+func getMany(ctx context.Context, get func(context.Context, cid.Cid) (format.Node, error), cc []cid.Cid) <-chan *format.NodeOption {
+	// Buffered so that workers never block, even if the caller stops reading.
+	out := make(chan *format.NodeOption, len(cc)+1)
+	go func() {
+		defer close(out)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		sem := make(chan struct{}, concurrency)
+		for _, c := range cc {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				out <- &format.NodeOption{Err: ctx.Err()}
+				return
+			}
+			wg.Add(1)
+			go func(c cid.Cid) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				node, err := get(ctx, c)
+				out <- &format.NodeOption{Node: node, Err: err}
+			}(c)
+		}
+	}()
+	return out
+}
+
+// addMany implements AddMany on top of a function adding a single node, calling it in parallel for
+// up to concurrency nodes at a time. It returns the first error encountered, after which no further
+// nodes are added.
+func addMany(ctx context.Context, add func(context.Context, format.Node) error, nodes []format.Node) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	sem := make(chan struct{}, concurrency)
+loop:
+	for _, node := range nodes {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			fail(ctx.Err())
+			break loop
+		}
+		wg.Add(1)
+		go func(node format.Node) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := add(ctx, node)
+			if err != nil {
+				fail(err)
+			}
+		}(node)
+	}
+	wg.Wait()
+	return firstErr
+}
@@ -16,64 +16,822 @@
 package nodeservice
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/google/ent/utils"
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
 	"github.com/multiformats/go-multihash"
 )
 
+// WriteMode selects the inner services of a Multiplex that writes go to, and how many of them must
+// succeed.
+type WriteMode int
+
+const (
+	// WritePrimary only writes to the first inner service.
+	WritePrimary WriteMode = iota
+	// WriteAll writes to all the inner services, and fails if any of them fails.
+	WriteAll
+	// WriteQuorum writes to all the inner services, and succeeds if at least Quorum of them succeed.
+	WriteQuorum
+)
+
+var writeModeNames = map[WriteMode]string{
+	WritePrimary: "primary",
+	WriteAll:     "all",
+	WriteQuorum:  "quorum",
+}
+
+func (m WriteMode) String() string {
+	if name, ok := writeModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("WriteMode(%d)", int(m))
+}
+
+// ParseWriteMode parses the name of a write mode, as returned by WriteMode.String. The empty string
+// is parsed as WritePrimary.
+func ParseWriteMode(s string) (WriteMode, error) {
+	if s == "" {
+		return WritePrimary, nil
+	}
+	for m, name := range writeModeNames {
+		if s == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid write mode %q", s)
+}
+
+// ReadMode selects how reads query the inner services of a Multiplex.
+type ReadMode int
+
+const (
+	// ReadSequential tries the inner services one at a time, until one succeeds.
+	ReadSequential ReadMode = iota
+	// ReadParallel queries all the inner services at once.
+	ReadParallel
+	// ReadHedged queries the next inner service whenever the ones queried so far have not responded
+	// within HedgeDelay, or as soon as one of them fails.
+	ReadHedged
+)
+
+var readModeNames = map[ReadMode]string{
+	ReadSequential: "sequential",
+	ReadParallel:   "parallel",
+	ReadHedged:     "hedged",
+}
+
+func (m ReadMode) String() string {
+	if name, ok := readModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("ReadMode(%d)", int(m))
+}
+
+// ParseReadMode parses the name of a read mode, as returned by ReadMode.String. The empty string is
+// parsed as ReadSequential.
+func ParseReadMode(s string) (ReadMode, error) {
+	if s == "" {
+		return ReadSequential, nil
+	}
+	for m, name := range readModeNames {
+		if s == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid read mode %q", s)
+}
+
+// DefaultHedgeDelay is the delay after which hedged reads query the next inner service, unless
+// specified.
+const DefaultHedgeDelay = 100 * time.Millisecond
+
+// readRepairMaxBytes is the maximum size of objects repaired by GetObjectReader, which needs to
+// buffer them in memory.
+const readRepairMaxBytes = 16 << 20
+
+// Multiplex is a NodeService combining several inner services. Reads query the inner services as
+// selected by ReadMode, and return the first result that matches the requested hash; the requests
+// still in flight are then cancelled. Writes go to the inner services selected by WriteMode, in
+// parallel.
+//
+// Operations that fail return a *MultiplexError with the error of each inner service that was
+// tried.
 type Multiplex struct {
-	Inner []NodeService
+	Inner     []NodeService
+	WriteMode WriteMode
+	// Quorum is the number of inner services that writes must succeed on in WriteQuorum mode.
+	Quorum int
+	// Repairs, if set, enables read repair: objects found by reads on an inner service are copied,
+	// through Repairs, to the services queried before that reported them as not found.
+	Repairs  *RepairQueue
+	ReadMode ReadMode
+	// HedgeDelay is the delay used in ReadHedged mode; if zero, DefaultHedgeDelay is used.
+	HedgeDelay time.Duration
+	// Stats, if set, records the latency of the inner services, and reads query them fastest first
+	// rather than in order.
+	Stats *LatencyStats
+}
+
+// MultiplexError aggregates the errors returned by the inner services of a Multiplex.
+type MultiplexError struct {
+	// Errors maps the index of each failed inner service to its error.
+	Errors map[int]error
+}
+
+func (e *MultiplexError) Error() string {
+	indices := make([]int, 0, len(e.Errors))
+	for i := range e.Errors {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	s := make([]string, len(indices))
+	for j, i := range indices {
+		s[j] = fmt.Sprintf("backend %d: %v", i, e.Errors[i])
+	}
+	return strings.Join(s, "; ")
+}
+
+// Is reports whether all the aggregated errors match target, so that e.g. an object that is not
+// found on any inner service can be checked with errors.Is(err, ErrNotFound).
+func (e *MultiplexError) Is(target error) bool {
+	if len(e.Errors) == 0 {
+		return false
+	}
+	for _, err := range e.Errors {
+		if !errors.Is(err, target) {
+			return false
+		}
+	}
+	return true
+}
+
+// read calls f with the index of each inner service, according to ReadMode, until one call
+// succeeds, and returns the index of the successful service, or the aggregated errors if none
+// succeeds. f must store its result by index, as
+// it may be called concurrently, and not use it if its context is cancelled. If Repairs is set,
+// repair is then called with the index of the successful service, and the services that reported
+// ErrNotFound.
+func (s Multiplex) read(ctx context.Context, f func(context.Context, int) error, repair func(int, []NodeService)) (int, error) {
+	if len(s.Inner) == 0 {
+		return -1, fmt.Errorf("no inner services")
+	}
+	order := make([]int, len(s.Inner))
+	for i := range order {
+		order[i] = i
+	}
+	if s.Stats != nil {
+		order = s.Stats.Order(len(s.Inner))
+	}
+	var winner int
+	var errs map[int]error
+	if s.ReadMode == ReadSequential {
+		winner, errs = s.readSequential(ctx, order, f)
+	} else {
+		winner, errs = s.readConcurrent(ctx, order, f)
+	}
+	if winner < 0 {
+		return -1, &MultiplexError{Errors: errs}
+	}
+	if s.Repairs != nil && repair != nil {
+		targets := []NodeService{}
+		for i, inner := range s.Inner {
+			if errors.Is(errs[i], ErrNotFound) {
+				targets = append(targets, inner)
+			}
+		}
+		if len(targets) > 0 {
+			repair(winner, targets)
+		}
+	}
+	return winner, nil
+}
+
+// attempt calls f for the inner service i with ctx, derived from parent, and records its latency.
+// If ctx was cancelled because another service responded first, the time until then is recorded as
+// a successful request, since the service would have taken at least as long.
+func (s Multiplex) attempt(parent context.Context, ctx context.Context, i int, f func(context.Context, int) error) error {
+	start := time.Now()
+	err := f(ctx, i)
+	if s.Stats != nil && parent.Err() == nil {
+		if ctx.Err() != nil {
+			s.Stats.Record(i, time.Since(start), nil)
+		} else {
+			s.Stats.Record(i, time.Since(start), err)
+		}
+	}
+	return err
+}
+
+// readSequential returns the index of the first inner service for which f succeeds, or -1, and the
+// errors of the others.
+func (s Multiplex) readSequential(ctx context.Context, order []int, f func(context.Context, int) error) (int, map[int]error) {
+	errs := map[int]error{}
+	for _, i := range order {
+		err := s.attempt(ctx, ctx, i, f)
+		if err == nil {
+			return i, errs
+		}
+		errs[i] = err
+	}
+	return -1, errs
+}
+
+// readConcurrent is like readSequential, but calls f concurrently, for all the inner services at
+// once or hedging according to ReadMode. Calls still in flight are cancelled once one succeeds.
+func (s Multiplex) readConcurrent(parent context.Context, order []int, f func(context.Context, int) error) (int, map[int]error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	type result struct {
+		i   int
+		err error
+	}
+	// Buffered so that cancelled calls never block.
+	results := make(chan result, len(order))
+	launched, pending := 0, 0
+	launch := func() {
+		i := order[launched]
+		launched++
+		pending++
+		go func() {
+			results <- result{i, s.attempt(parent, ctx, i, f)}
+		}()
+	}
+	launch()
+	if s.ReadMode == ReadParallel {
+		for launched < len(order) {
+			launch()
+		}
+	}
+
+	hedgeDelay := s.HedgeDelay
+	if hedgeDelay == 0 {
+		hedgeDelay = DefaultHedgeDelay
+	}
+	errs := map[int]error{}
+	for pending > 0 {
+		var hedge <-chan time.Time
+		var timer *time.Timer
+		if launched < len(order) {
+			timer = time.NewTimer(hedgeDelay)
+			hedge = timer.C
+		}
+		var r *result
+		select {
+		case res := <-results:
+			r = &res
+		case <-hedge:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if r == nil {
+			launch()
+			continue
+		}
+		pending--
+		if r.err == nil {
+			return r.i, errs
+		}
+		errs[r.i] = r.err
+		if launched < len(order) {
+			launch()
+		}
+	}
+	return -1, errs
+}
+
+// checkObject returns an error if b does not match h, so that corrupted responses are never
+// returned, even by inner services that do not verify them.
+func checkObject(h multihash.Multihash, b []byte) error {
+	return utils.VerifyHash(h, b)
+}
+
+// writeTargets returns the indices of the inner services that writes go to, and how many of them
+// must succeed.
+func (s Multiplex) writeTargets() ([]int, int, error) {
+	if len(s.Inner) == 0 {
+		return nil, 0, fmt.Errorf("no inner services")
+	}
+	switch s.WriteMode {
+	case WritePrimary:
+		return []int{0}, 1, nil
+	case WriteAll:
+		all := make([]int, len(s.Inner))
+		for i := range s.Inner {
+			all[i] = i
+		}
+		return all, len(all), nil
+	case WriteQuorum:
+		if s.Quorum <= 0 || s.Quorum > len(s.Inner) {
+			return nil, 0, fmt.Errorf("invalid quorum %d for %d backends", s.Quorum, len(s.Inner))
+		}
+		all := make([]int, len(s.Inner))
+		for i := range s.Inner {
+			all[i] = i
+		}
+		return all, s.Quorum, nil
+	default:
+		return nil, 0, fmt.Errorf("invalid write mode %v", s.WriteMode)
+	}
+}
+
+// write calls f in parallel on the inner services selected by WriteMode, and returns the aggregated
+// errors if fewer of them succeed than required. f is passed the index of the service.
+func (s Multiplex) write(f func(int, NodeService) error) error {
+	targets, required, err := s.writeTargets()
+	if err != nil {
+		return err
+	}
+	var mu sync.Mutex
+	errs := map[int]error{}
+	var wg sync.WaitGroup
+	for _, i := range targets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := f(i, s.Inner[i])
+			if err != nil {
+				mu.Lock()
+				errs[i] = err
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(targets)-len(errs) < required {
+		return &MultiplexError{Errors: errs}
+	}
+	return nil
 }
 
 func (s Multiplex) GetObject(ctx context.Context, h multihash.Multihash) ([]byte, error) {
-	for _, i := range s.Inner {
-		b, err := i.GetObject(ctx, h)
+	bs := make([][]byte, len(s.Inner))
+	winner, err := s.read(ctx, func(ctx context.Context, i int) error {
+		b, err := s.Inner[i].GetObject(ctx, h)
 		if err != nil {
-			continue
+			return err
 		}
-		return b, nil
+		err = checkObject(h, b)
+		if err != nil {
+			return err
+		}
+		bs[i] = b
+		return nil
+	}, func(winner int, targets []NodeService) {
+		s.repairObject(targets, h, bs[winner])
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("not found")
+	return bs[winner], nil
 }
 
+// AddObject returns the hash computed by the first inner service that the object was written to.
 func (s Multiplex) AddObject(ctx context.Context, b []byte) (multihash.Multihash, error) {
-	return s.Inner[0].AddObject(ctx, b)
+	hs := make([]multihash.Multihash, len(s.Inner))
+	err := s.write(func(i int, inner NodeService) error {
+		var err error
+		hs[i], err = inner.AddObject(ctx, b)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return firstHash(hs), nil
+}
+
+// firstHash returns the first non-nil hash, which is that computed by the first successful write.
+func firstHash(hs []multihash.Multihash) multihash.Multihash {
+	for _, h := range hs {
+		if h != nil {
+			return h
+		}
+	}
+	return nil
+}
+
+// GetObjectReader streams the object from the first inner service that opens a stream for it; in
+// ReadParallel and ReadHedged modes, the streams opened by the other services are closed. Streams
+// are verified by the inner services as they are read, so a corrupted object only fails once it
+// has been read to its end; there is then no fallback to the other services, since its content has
+// already been returned, and callers that need one should use GetObject instead, which only
+// returns verified objects. With Repairs, objects up to
+// readRepairMaxBytes are also buffered, and copied to the services before it once fully read and
+// verified.
+func (s Multiplex) GetObjectReader(ctx context.Context, h multihash.Multihash) (io.ReadCloser, error) {
+	var mu sync.Mutex
+	done := false
+	rs := make([]io.ReadCloser, len(s.Inner))
+	var targets []NodeService
+	winner, err := s.read(ctx, func(attemptCtx context.Context, i int) error {
+		// The context of the attempt is cancelled as soon as a winner is chosen, while the winning
+		// stream must remain readable, so streams get a context of their own, which is only
+		// cancelled if the attempt is abandoned before the stream opens.
+		streamCtx, cancel := context.WithCancel(ctx)
+		opened := make(chan struct{})
+		go func() {
+			select {
+			case <-attemptCtx.Done():
+				cancel()
+			case <-opened:
+			}
+		}()
+		r, err := s.Inner[i].GetObjectReader(streamCtx, h)
+		close(opened)
+		if err != nil {
+			cancel()
+			return err
+		}
+		r = &cancelingReader{inner: r, cancel: cancel}
+		mu.Lock()
+		defer mu.Unlock()
+		if done {
+			// Another service won the race.
+			r.Close()
+			return context.Canceled
+		}
+		rs[i] = r
+		return nil
+	}, func(_ int, t []NodeService) {
+		targets = t
+	})
+	mu.Lock()
+	done = true
+	for i, r := range rs {
+		if r != nil && i != winner {
+			r.Close()
+		}
+	}
+	mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	r := rs[winner]
+	if len(targets) == 0 {
+		return r, nil
+	}
+	return &repairingReader{
+		inner: r,
+		repair: func(b []byte) {
+			s.repairObject(targets, h, b)
+		},
+	}, nil
+}
+
+// repairObject queues a repair writing b, which has already been verified against h, to targets.
+// It is written as a raw node, so that it is hashed with the hash function of h rather than the
+// default one.
+func (s Multiplex) repairObject(targets []NodeService, h multihash.Multihash, b []byte) {
+	decoded, err := multihash.Decode(h)
+	if err != nil {
+		return
+	}
+	node, err := merkledag.NewRawNodeWPrefix(b, cid.Prefix{
+		Version:  1,
+		Codec:    cid.Raw,
+		MhType:   decoded.Code,
+		MhLength: decoded.Length,
+	})
+	if err != nil {
+		return
+	}
+	s.Repairs.add(targets, node)
+}
+
+// cancelingReader cancels the context of the stream inner once it is closed.
+type cancelingReader struct {
+	inner  io.ReadCloser
+	cancel context.CancelFunc
 }
 
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	return r.inner.Read(p)
+}
+
+func (r *cancelingReader) Close() error {
+	err := r.inner.Close()
+	r.cancel()
+	return err
+}
+
+// repairingReader buffers the content read from inner, and passes it to repair once inner reaches
+// EOF, which means that it has been verified, unless it exceeds readRepairMaxBytes.
+type repairingReader struct {
+	inner    io.ReadCloser
+	buf      bytes.Buffer
+	tooBig   bool
+	repair   func([]byte)
+	repaired bool
+}
+
+func (r *repairingReader) Read(p []byte) (int, error) {
+	n, err := r.inner.Read(p)
+	if !r.tooBig {
+		if r.buf.Len()+n > readRepairMaxBytes {
+			r.tooBig = true
+			r.buf = bytes.Buffer{}
+		} else {
+			r.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !r.tooBig && !r.repaired {
+		r.repaired = true
+		r.repair(r.buf.Bytes())
+	}
+	return n, err
+}
+
+func (r *repairingReader) Close() error {
+	return r.inner.Close()
+}
+
+// PutObject streams the object to all the selected inner services at once. A service that fails
+// does not stop the others from receiving the rest of the object.
+func (s Multiplex) PutObject(ctx context.Context, r io.Reader) (multihash.Multihash, error) {
+	targets, _, err := s.writeTargets()
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 1 {
+		return s.Inner[targets[0]].PutObject(ctx, r)
+	}
+	writers := make(map[int]*io.PipeWriter, len(targets))
+	readers := make(map[int]*io.PipeReader, len(targets))
+	for _, i := range targets {
+		readers[i], writers[i] = io.Pipe()
+	}
+	go func() {
+		_, err := io.Copy(&fanOutWriter{writers: writers}, r)
+		for _, w := range writers {
+			w.CloseWithError(err)
+		}
+	}()
+	hs := make([]multihash.Multihash, len(s.Inner))
+	err = s.write(func(i int, inner NodeService) error {
+		var err error
+		hs[i], err = inner.PutObject(ctx, readers[i])
+		// Drop the service from the fan-out if it stopped reading early, e.g. after an error.
+		readers[i].Close()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return firstHash(hs), nil
+}
+
+// fanOutWriter writes to all its writers, dropping those that fail. It only fails once all of them
+// have failed.
+type fanOutWriter struct {
+	writers map[int]*io.PipeWriter
+}
+
+func (w *fanOutWriter) Write(p []byte) (int, error) {
+	var lastErr error
+	for i, pw := range w.writers {
+		_, err := pw.Write(p)
+		if err != nil {
+			delete(w.writers, i)
+			lastErr = err
+		}
+	}
+	if len(w.writers) == 0 {
+		return 0, lastErr
+	}
+	return len(p), nil
+}
+
+// MissingObjects returns, in WritePrimary mode, the hashes that are missing from all the inner
+// services, so that objects already present on any of them are not written again. In the other
+// modes, it returns the hashes that are missing from any of them, so that writes reach all of
+// them. In all modes, services that fail are assumed to be missing all the objects, except for the
+// first one in WritePrimary mode, whose error is returned, since writes only go to it.
+func (s Multiplex) MissingObjects(ctx context.Context, hs []multihash.Multihash) ([]multihash.Multihash, error) {
+	if s.WriteMode == WritePrimary {
+		if len(s.Inner) == 0 {
+			return nil, fmt.Errorf("no inner services")
+		}
+		missing := hs
+		for i, inner := range s.Inner {
+			if len(missing) == 0 {
+				break
+			}
+			m, err := inner.MissingObjects(ctx, missing)
+			if err != nil {
+				if i == 0 {
+					return nil, &MultiplexError{Errors: map[int]error{i: err}}
+				}
+				continue
+			}
+			missing = m
+		}
+		return missing, nil
+	}
+
+	missing := map[string]bool{}
+	errs := map[int]error{}
+	for i, inner := range s.Inner {
+		m, err := inner.MissingObjects(ctx, hs)
+		if err != nil {
+			errs[i] = err
+			m = hs
+		}
+		for _, h := range m {
+			missing[string(h)] = true
+		}
+	}
+	if len(errs) == len(s.Inner) {
+		return nil, &MultiplexError{Errors: errs}
+	}
+	out := []multihash.Multihash{}
+	for _, h := range hs {
+		if missing[string(h)] {
+			out = append(out, h)
+		}
+	}
+	return out, nil
+}
+
+func (s Multiplex) AddObjects(ctx context.Context, bs [][]byte) ([]multihash.Multihash, error) {
+	hss := make([][]multihash.Multihash, len(s.Inner))
+	err := s.write(func(i int, inner NodeService) error {
+		var err error
+		hss[i], err = inner.AddObjects(ctx, bs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, hs := range hss {
+		if hs != nil {
+			return hs, nil
+		}
+	}
+	return nil, nil
+}
+
+// Has returns true if any inner service has the node. If none of them has it, but some of them
+// failed, it returns the aggregated errors, since the node may be present on those.
 func (s Multiplex) Has(ctx context.Context, c cid.Cid) (bool, error) {
-	for _, i := range s.Inner {
-		ok, _ := i.Has(ctx, c)
+	errs := map[int]error{}
+	for i, inner := range s.Inner {
+		ok, err := inner.Has(ctx, c)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
 		if ok {
-			return ok, nil
+			return true, nil
 		}
 	}
+	if len(errs) > 0 {
+		return false, &MultiplexError{Errors: errs}
+	}
 	return false, nil
 }
 
 func (s Multiplex) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
-	for _, i := range s.Inner {
-		node, err := i.Get(ctx, c)
+	nodes := make([]format.Node, len(s.Inner))
+	winner, err := s.read(ctx, func(ctx context.Context, i int) error {
+		node, err := s.Inner[i].Get(ctx, c)
 		if err != nil {
-			continue
+			return err
+		}
+		if !node.Cid().Equals(c) {
+			return &utils.HashMismatchError{Expected: c.String(), Actual: node.Cid().String()}
 		}
-		return node, nil
+		nodes[i] = node
+		return nil
+	}, func(w int, targets []NodeService) {
+		s.Repairs.add(targets, nodes[w])
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("not found")
+	return nodes[winner], nil
 }
 
+// multiplexBatchSize is the maximum number of nodes requested at once from the inner services by
+// GetMany.
+const multiplexBatchSize = 100
+
+// GetMany reads nodes in batches of multiplexBatchSize, each of them fetched with the GetMany of the
+// inner services, queried as selected by ReadMode, until one of them returns the whole batch. If
+// none does, the nodes they returned are used, and the remaining ones are read one at a time with
+// Get, which reports their errors.
 func (s Multiplex) GetMany(ctx context.Context, cc []cid.Cid) <-chan *format.NodeOption {
-	return nil
+	out := make(chan *format.NodeOption, len(cc)+1)
+	go func() {
+		defer close(out)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		sem := make(chan struct{}, concurrency)
+		for start := 0; start < len(cc); start += multiplexBatchSize {
+			end := start + multiplexBatchSize
+			if end > len(cc) {
+				end = len(cc)
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				out <- &format.NodeOption{Err: ctx.Err()}
+				return
+			}
+			wg.Add(1)
+			go func(batch []cid.Cid) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				s.getBatch(ctx, batch, out)
+			}(cc[start:end])
+		}
+	}()
+	return out
+}
+
+// getBatch reads the nodes in batch, as described in GetMany, and sends them to out.
+func (s Multiplex) getBatch(ctx context.Context, batch []cid.Cid, out chan<- *format.NodeOption) {
+	// The nodes returned by each inner service, including those that did not return all of them.
+	found := make([]map[cid.Cid]format.Node, len(s.Inner))
+	winner, err := s.read(ctx, func(ctx context.Context, i int) error {
+		wanted := make(map[cid.Cid]bool, len(batch))
+		for _, c := range batch {
+			wanted[c] = true
+		}
+		nodes := map[cid.Cid]format.Node{}
+		var firstErr error
+		for o := range s.Inner[i].GetMany(ctx, batch) {
+			if o.Err != nil {
+				if firstErr == nil {
+					firstErr = o.Err
+				}
+				continue
+			}
+			// Keyed by the CID computed from the content, so that corrupted nodes never match.
+			if wanted[o.Node.Cid()] {
+				nodes[o.Node.Cid()] = o.Node
+			}
+		}
+		found[i] = nodes
+		if firstErr != nil {
+			return firstErr
+		}
+		if len(nodes) < len(wanted) {
+			return ErrNotFound
+		}
+		return nil
+	}, func(w int, targets []NodeService) {
+		nodes := make([]format.Node, 0, len(found[w]))
+		for _, node := range found[w] {
+			nodes = append(nodes, node)
+		}
+		s.Repairs.add(targets, nodes...)
+	})
+	if err == nil {
+		for _, c := range batch {
+			out <- &format.NodeOption{Node: found[winner][c]}
+		}
+		return
+	}
+	// All the inner services have completed, so their partial results can be merged.
+	for _, c := range batch {
+		var node format.Node
+		for _, nodes := range found {
+			if n, ok := nodes[c]; ok {
+				node = n
+				break
+			}
+		}
+		var err error
+		if node == nil {
+			node, err = s.Get(ctx, c)
+		}
+		out <- &format.NodeOption{Node: node, Err: err}
+	}
 }
 
 func (s Multiplex) Add(ctx context.Context, node format.Node) error {
-	return s.Inner[0].Add(ctx, node)
+	return s.write(func(i int, inner NodeService) error {
+		return inner.Add(ctx, node)
+	})
 }
 
 func (s Multiplex) AddMany(ctx context.Context, nodes []format.Node) error {
-	return fmt.Errorf("not implemented")
+	return s.write(func(i int, inner NodeService) error {
+		return inner.AddMany(ctx, nodes)
+	})
 }
 
 func (s Multiplex) Remove(ctx context.Context, c cid.Cid) error {
@@ -17,6 +17,7 @@ package nodeservice
 
 import (
 	"context"
+	"io"
 
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
@@ -26,6 +27,16 @@ import (
 type ObjectStore interface {
 	GetObject(ctx context.Context, h multihash.Multihash) ([]byte, error)
 	AddObject(ctx context.Context, b []byte) (multihash.Multihash, error)
+	// GetObjectReader is like GetObject, but streams the object; the hash is verified once the
+	// reader reaches EOF.
+	GetObjectReader(ctx context.Context, h multihash.Multihash) (io.ReadCloser, error)
+	// PutObject is like AddObject, but streams the object from r.
+	PutObject(ctx context.Context, r io.Reader) (multihash.Multihash, error)
+	// MissingObjects returns the subset of hashes that are not present in the store.
+	MissingObjects(ctx context.Context, hs []multihash.Multihash) ([]multihash.Multihash, error)
+	// AddObjects is like AddObject, for many objects at once. The returned hashes are in the same
+	// order as the objects.
+	AddObjects(ctx context.Context, bs [][]byte) ([]multihash.Multihash, error)
 }
 
 // https://github.com/ipfs/go-ipld-format/blob/579737706ba5da3e550111621e2ab1bf122ed53f/merkledag.go
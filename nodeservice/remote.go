@@ -20,20 +20,32 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"path"
+	"sync"
 
+	"github.com/google/ent/datastore"
+	"github.com/google/ent/objectstore"
 	"github.com/google/ent/utils"
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/multiformats/go-multihash"
 )
 
+// Remote is a NodeService backed by the HTTP API of a server. Requests are sent with ctx, and
+// idempotent ones are retried. Errors are ErrNotFound, utils.ErrUnauthorized, *utils.ServerError
+// or *utils.HashMismatchError, or describe transport failures.
 type Remote struct {
 	APIURL string
+	// Token, if set, is sent as a bearer token with every request.
+	Token string
+	// Client is used to send requests; if nil, utils.DefaultHTTPClient is used.
+	Client *http.Client
+	// MaxRetries is the number of times idempotent requests are retried; see utils.HTTPClient.
+	MaxRetries int
 }
 
 type UploadRequest struct {
@@ -60,44 +72,148 @@ type GetResponse struct {
 	Content []byte
 }
 
+type GetManyRequest struct {
+	Roots []string
+}
+
+// GetManyResponse maps each node id to its content; nodes that were not found are omitted.
+type GetManyResponse struct {
+	Contents map[string][]byte
+}
+
+// getManyBatchSize is the maximum number of nodes requested from the server in a single request.
+const getManyBatchSize = 100
+
+type ObjectsMissingRequest struct {
+	Hashes []string
+}
+
+type ObjectsMissingResponse struct {
+	Missing []string
+}
+
+// ObjectsUpdateResponse contains the hashes of objects uploaded in a batch, in the same order as
+// they were sent.
+type ObjectsUpdateResponse struct {
+	Hashes []string
+}
+
+const (
+	// objectsBatchSize is the maximum number of objects sent to the server in a single request.
+	objectsBatchSize = 1000
+	// objectsBatchBytes is the size after which a batch of objects is sent to the server, even if
+	// it contains fewer than objectsBatchSize objects.
+	objectsBatchBytes = 16 << 20
+)
+
 var (
-	ErrNotFound = fmt.Errorf("not found")
+	// ErrNotFound is the same error as datastore.ErrNotFound, so that it is returned by all
+	// implementations of NodeService.
+	ErrNotFound = datastore.ErrNotFound
 )
 
-func (s Remote) GetObject(ctx context.Context, h multihash.Multihash) ([]byte, error) {
-	// res, err := http.Get(s.APIURL + "/api/objects/" + h.HexString())
-	u, _ := url.Parse(s.APIURL)
-	u.Path = path.Join(u.Path, h.HexString())
-	res, err := http.Get(u.String())
-	if err != nil {
-		log.Fatal(err)
+func (s Remote) client() utils.HTTPClient {
+	return utils.HTTPClient{
+		Client:     s.Client,
+		Token:      s.Token,
+		MaxRetries: s.MaxRetries,
 	}
+}
+
+// checkStatus closes the body of the response and returns an error if its status is not 2xx.
+func checkStatus(res *http.Response) error {
 	if res.StatusCode == http.StatusNotFound {
-		return nil, ErrNotFound
+		res.Body.Close()
+		return ErrNotFound
 	}
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error: %v", res.Status)
+	err := utils.CheckStatus(res)
+	if err != nil {
+		res.Body.Close()
 	}
+	return err
+}
 
-	bytes, err := ioutil.ReadAll(res.Body)
+// postJSON sends r as JSON to the API endpoint at path, which must be idempotent, and decodes the
+// JSON response into v.
+func (s Remote) postJSON(ctx context.Context, path string, r interface{}, v interface{}) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	res, err := s.client().Do(ctx, http.MethodPost, s.APIURL+path, nil, body, true)
+	if err != nil {
+		return err
+	}
+	err = checkStatus(res)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (s Remote) GetObject(ctx context.Context, h multihash.Multihash) ([]byte, error) {
+	r, err := s.GetObjectReader(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	bytes, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
 	return bytes, nil
 }
 
+func (s Remote) GetObjectReader(ctx context.Context, h multihash.Multihash) (io.ReadCloser, error) {
+	u, err := url.Parse(s.APIURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, h.HexString())
+	res, err := s.client().Do(ctx, http.MethodGet, u.String(), nil, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	err = checkStatus(res)
+	if err != nil {
+		return nil, err
+	}
+	return objectstore.NewVerifyingReader(res.Body, h)
+}
+
+// uploadURL returns the URL of the given upload endpoint, asking the server to hash objects with
+// hashType rather than with its own default.
+func (s Remote) uploadURL(path string, hashType uint64) string {
+	return s.APIURL + path + "?hash=" + url.QueryEscape(utils.HashName(hashType))
+}
+
+// AddObject uploads the object; unlike PutObject, it is retried, since its body can be resent.
+// Objects are hashed with utils.DefaultHashType, like those added to a DataStore.
 func (s Remote) AddObject(ctx context.Context, b []byte) (multihash.Multihash, error) {
-	// res, err := http.Post(s.APIURL+"/api/objects", "", bytes.NewReader(b))
-	res, err := http.Post(s.APIURL, "", bytes.NewReader(b))
+	res, err := s.client().Do(ctx, http.MethodPost, s.uploadURL("", utils.DefaultHashType), nil, b, true)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	if res.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("not found")
+	return readObjectHash(res)
+}
+
+func (s Remote) PutObject(ctx context.Context, r io.Reader) (multihash.Multihash, error) {
+	res, err := s.client().DoReader(ctx, http.MethodPost, s.uploadURL("", utils.DefaultHashType), nil, r)
+	if err != nil {
+		return nil, err
 	}
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error: %v", res.Status)
+	return readObjectHash(res)
+}
+
+// readObjectHash returns the hash of an uploaded object from the response of the server.
+func readObjectHash(res *http.Response) (multihash.Multihash, error) {
+	err := checkStatus(res)
+	if err != nil {
+		return nil, err
 	}
+	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
@@ -110,24 +226,123 @@ func (s Remote) AddObject(ctx context.Context, b []byte) (multihash.Multihash, e
 	return h, nil
 }
 
-func (s Remote) Has(ctx context.Context, c cid.Cid) (bool, error) {
-	r := GetRequest{
-		Root: c.String(),
-		Path: "",
+func (s Remote) MissingObjects(ctx context.Context, hs []multihash.Multihash) ([]multihash.Multihash, error) {
+	missing := []multihash.Multihash{}
+	for start := 0; start < len(hs); start += objectsBatchSize {
+		end := start + objectsBatchSize
+		if end > len(hs) {
+			end = len(hs)
+		}
+		m, err := s.missingObjectsBatch(ctx, hs[start:end])
+		if err != nil {
+			return nil, err
+		}
+		missing = append(missing, m...)
 	}
+	return missing, nil
+}
+
+func (s Remote) missingObjectsBatch(ctx context.Context, hs []multihash.Multihash) ([]multihash.Multihash, error) {
+	r := ObjectsMissingRequest{}
+	for _, h := range hs {
+		r.Hashes = append(r.Hashes, h.HexString())
+	}
+	response := ObjectsMissingResponse{}
+	err := s.postJSON(ctx, "/api/objects/missing", r, &response)
+	if err != nil {
+		return nil, err
+	}
+	missing := []multihash.Multihash{}
+	for _, m := range response.Missing {
+		h, err := utils.ParseHash(m)
+		if err != nil {
+			return nil, err
+		}
+		missing = append(missing, h)
+	}
+	return missing, nil
+}
+
+// AddObjects uploads objects in batches of up to objectsBatchSize objects or objectsBatchBytes
+// bytes, whichever is reached first. Objects are hashed with utils.DefaultHashType.
+func (s Remote) AddObjects(ctx context.Context, bs [][]byte) ([]multihash.Multihash, error) {
+	return s.addObjects(ctx, utils.DefaultHashType, bs)
+}
+
+func (s Remote) addObjects(ctx context.Context, hashType uint64, bs [][]byte) ([]multihash.Multihash, error) {
+	hs := []multihash.Multihash{}
+	start := 0
+	size := 0
+	for end, b := range bs {
+		if end > start && (end-start == objectsBatchSize || size+len(b) > objectsBatchBytes) {
+			batchHashes, err := s.addObjectsBatch(ctx, hashType, bs[start:end])
+			if err != nil {
+				return nil, err
+			}
+			hs = append(hs, batchHashes...)
+			start = end
+			size = 0
+		}
+		size += len(b)
+	}
+	if start < len(bs) {
+		batchHashes, err := s.addObjectsBatch(ctx, hashType, bs[start:])
+		if err != nil {
+			return nil, err
+		}
+		hs = append(hs, batchHashes...)
+	}
+	return hs, nil
+}
+
+func (s Remote) addObjectsBatch(ctx context.Context, hashType uint64, bs [][]byte) ([]multihash.Multihash, error) {
 	buf := bytes.Buffer{}
-	json.NewEncoder(&buf).Encode(r)
-	res, err := http.Post(s.APIURL+"/api/get", "", &buf)
+	for _, b := range bs {
+		utils.WriteLengthPrefixed(&buf, b)
+	}
+	// Uploading the same objects again is harmless, so the request can be retried.
+	res, err := s.client().Do(ctx, http.MethodPost, s.uploadURL("/api/objects/batch", hashType), nil, buf.Bytes(), true)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	if res.StatusCode == http.StatusOK {
-		return true, nil
+	err = checkStatus(res)
+	if err != nil {
+		return nil, err
 	}
-	if res.StatusCode == http.StatusNotFound {
+	defer res.Body.Close()
+
+	response := ObjectsUpdateResponse{}
+	err = json.NewDecoder(res.Body).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Hashes) != len(bs) {
+		return nil, fmt.Errorf("invalid number of hashes; wanted: %d, got: %d", len(bs), len(response.Hashes))
+	}
+	hs := []multihash.Multihash{}
+	for _, hex := range response.Hashes {
+		h, err := utils.ParseHash(hex)
+		if err != nil {
+			return nil, err
+		}
+		hs = append(hs, h)
+	}
+	return hs, nil
+}
+
+func (s Remote) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	r := GetRequest{
+		Root: c.String(),
+		Path: "",
+	}
+	response := GetResponse{}
+	err := s.postJSON(ctx, "/api/get", r, &response)
+	if err == ErrNotFound {
 		return false, nil
+	} else if err != nil {
+		return false, err
 	}
-	return false, fmt.Errorf("invalid status code: %d", res.StatusCode)
+	return true, nil
 }
 
 func (s Remote) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
@@ -135,78 +350,122 @@ func (s Remote) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
 		Root: c.String(),
 		Path: "",
 	}
-	buf := bytes.Buffer{}
-	json.NewEncoder(&buf).Encode(r)
-	res, err := http.Post(s.APIURL+"/api/get", "", &buf)
+	response := GetResponse{}
+	err := s.postJSON(ctx, "/api/get", r, &response)
 	if err != nil {
-		log.Fatal(err)
-	}
-	if res.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("not found")
-	}
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error: %v", res.Status)
+		return nil, err
 	}
-
-	response := GetResponse{}
-	err = json.NewDecoder(res.Body).Decode(&response)
+	node, err := utils.ParseNodeFromBytes(c, response.Content)
 	if err != nil {
 		return nil, err
 	}
-
-	switch c.Prefix().Codec {
-	case cid.DagProtobuf:
-		return utils.ParseProtoNode(response.Content)
-	case cid.Raw:
-		return utils.ParseRawNode(response.Content)
-	default:
-		return nil, fmt.Errorf("invalid codec")
+	if !node.Cid().Equals(c) {
+		return nil, &utils.HashMismatchError{Expected: c.String(), Actual: node.Cid().String()}
 	}
+	return node, nil
 }
 
+// GetMany fetches nodes in batches of getManyBatchSize, issuing up to concurrency requests in
+// parallel. Nodes not returned by the server are reported as ErrNotFound.
 func (s Remote) GetMany(ctx context.Context, cc []cid.Cid) <-chan *format.NodeOption {
-	return nil
+	out := make(chan *format.NodeOption, len(cc)+1)
+	go func() {
+		defer close(out)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		sem := make(chan struct{}, concurrency)
+		for start := 0; start < len(cc); start += getManyBatchSize {
+			end := start + getManyBatchSize
+			if end > len(cc) {
+				end = len(cc)
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				out <- &format.NodeOption{Err: ctx.Err()}
+				return
+			}
+			wg.Add(1)
+			go func(batch []cid.Cid) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				nodes, err := s.getBatch(ctx, batch)
+				for _, c := range batch {
+					if err != nil {
+						out <- &format.NodeOption{Err: err}
+					} else if node, ok := nodes[c]; ok {
+						out <- &format.NodeOption{Node: node}
+					} else {
+						out <- &format.NodeOption{Err: ErrNotFound}
+					}
+				}
+			}(cc[start:end])
+		}
+	}()
+	return out
 }
 
-func (s Remote) Add(ctx context.Context, node format.Node) error {
-	blobType := ""
-	switch node.Cid().Prefix().Codec {
-	case cid.Raw:
-		blobType = "file"
-	case cid.DagProtobuf:
-		blobType = "directory"
-	}
-	r := UploadRequest{
-		Root: "",
-		Blobs: []UploadBlob{
-			{
-				Type:    blobType,
-				Path:    "",
-				Content: node.RawData(),
-			},
-		},
-	}
-	buf := bytes.Buffer{}
-	json.NewEncoder(&buf).Encode(r)
-	res, err := http.Post(s.APIURL+"/api/update", "", &buf)
-	if err != nil {
-		return fmt.Errorf("could not POST request: %v", err)
+func (s Remote) getBatch(ctx context.Context, cc []cid.Cid) (map[cid.Cid]format.Node, error) {
+	r := GetManyRequest{}
+	for _, c := range cc {
+		r.Roots = append(r.Roots, c.String())
 	}
-	resJson := UploadResponse{}
-	err = json.NewDecoder(res.Body).Decode(&resJson)
+	response := GetManyResponse{}
+	err := s.postJSON(ctx, "/api/getmany", r, &response)
 	if err != nil {
-		return fmt.Errorf("could not read response body: %v", err)
+		return nil, err
 	}
-	log.Printf("uploaded: %#v", resJson)
-	remoteHash := resJson.Root
-	if node.Cid().String() != remoteHash {
-		return fmt.Errorf("hash mismatch; local: %s, remote: %s", node.Cid().String(), remoteHash)
+
+	nodes := map[cid.Cid]format.Node{}
+	for _, c := range cc {
+		content, ok := response.Contents[c.String()]
+		if !ok {
+			continue
+		}
+		node, err := utils.ParseNodeFromBytes(c, content)
+		if err != nil {
+			return nil, err
+		}
+		if !node.Cid().Equals(c) {
+			return nil, &utils.HashMismatchError{Expected: c.String(), Actual: node.Cid().String()}
+		}
+		nodes[c] = node
 	}
-	return nil
+	return nodes, nil
 }
 
+func (s Remote) Add(ctx context.Context, node format.Node) error {
+	return s.AddMany(ctx, []format.Node{node})
+}
+
+// AddMany uploads nodes via the batch objects endpoint, hashing each of them with the hash function
+// of its CID, and checks that the hashes computed by the server match the local ones.
 func (s Remote) AddMany(ctx context.Context, nodes []format.Node) error {
-	return fmt.Errorf("not implemented")
+	byHashType := map[uint64][]format.Node{}
+	hashTypes := []uint64{}
+	for _, node := range nodes {
+		hashType := node.Cid().Prefix().MhType
+		if _, ok := byHashType[hashType]; !ok {
+			hashTypes = append(hashTypes, hashType)
+		}
+		byHashType[hashType] = append(byHashType[hashType], node)
+	}
+	for _, hashType := range hashTypes {
+		bs := [][]byte{}
+		for _, node := range byHashType[hashType] {
+			bs = append(bs, node.RawData())
+		}
+		hs, err := s.addObjects(ctx, hashType, bs)
+		if err != nil {
+			return err
+		}
+		for i, node := range byHashType[hashType] {
+			if !bytes.Equal(node.Cid().Hash(), hs[i]) {
+				return &utils.HashMismatchError{Expected: utils.Hash(node.Cid()), Actual: hs[i].HexString()}
+			}
+		}
+	}
+	return nil
 }
 
 func (s Remote) Remove(ctx context.Context, c cid.Cid) error {
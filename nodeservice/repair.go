@@ -0,0 +1,110 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodeservice
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	format "github.com/ipfs/go-ipld-format"
+)
+
+const (
+	// DefaultRepairWorkers is the number of repairs that a RepairQueue runs at once, unless
+	// specified.
+	DefaultRepairWorkers = 4
+	// DefaultRepairQueueSize is the number of repairs that may wait in a RepairQueue, unless
+	// specified; further repairs are dropped until the queue drains.
+	DefaultRepairQueueSize = 256
+)
+
+// readRepairTimeout bounds the time spent copying an object to the inner services that did not have
+// it.
+const readRepairTimeout = time.Minute
+
+type repair struct {
+	targets []NodeService
+	nodes   []format.Node
+}
+
+// RepairQueue runs the read repairs of a Multiplex in the background, with a bounded number of
+// workers and of pending repairs, so that reads are neither delayed by repairs nor cancel them.
+// Repairs are best effort: those that do not fit in the queue are dropped, and their errors are
+// ignored. Close must be called before exiting, to wait for the pending repairs.
+type RepairQueue struct {
+	mu      sync.Mutex
+	closed  bool
+	pending chan repair
+	wg      sync.WaitGroup
+}
+
+// NewRepairQueue starts a RepairQueue with the given number of workers, and room for size pending
+// repairs. Zero values select DefaultRepairWorkers and DefaultRepairQueueSize.
+func NewRepairQueue(workers, size int) *RepairQueue {
+	if workers <= 0 {
+		workers = DefaultRepairWorkers
+	}
+	if size <= 0 {
+		size = DefaultRepairQueueSize
+	}
+	q := &RepairQueue{
+		pending: make(chan repair, size),
+	}
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+func (q *RepairQueue) work() {
+	defer q.wg.Done()
+	for r := range q.pending {
+		// Each repair gets a context of its own, since the context of the read may be cancelled as
+		// soon as it returns.
+		ctx, cancel := context.WithTimeout(context.Background(), readRepairTimeout)
+		for _, t := range r.targets {
+			t.AddMany(ctx, r.nodes)
+		}
+		cancel()
+	}
+}
+
+// add queues a repair writing nodes to targets, unless the queue is full or closed.
+func (q *RepairQueue) add(targets []NodeService, nodes ...format.Node) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	select {
+	case q.pending <- repair{targets: targets, nodes: nodes}:
+	default:
+	}
+}
+
+// Close stops accepting repairs, and waits for the pending ones to complete, each of them bounded
+// by readRepairTimeout. It may be called several times.
+func (q *RepairQueue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.pending)
+	}
+	q.mu.Unlock()
+	q.wg.Wait()
+}
@@ -1,16 +1,14 @@
 package objectstore
 
 import (
-	"bytes"
 	"context"
-	"fmt"
+	"io"
 
 	"github.com/google/ent/datastore"
+	"github.com/google/ent/utils"
 	"github.com/multiformats/go-multihash"
 )
 
-const hashType = multihash.SHA2_256
-
 type Store struct {
 	Inner datastore.DataStore
 }
@@ -20,17 +18,58 @@ func (s Store) Get(ctx context.Context, h multihash.Multihash) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	actualHash, err := multihash.Sum(b, hashType, -1)
+	// The content is verified with the hash function of h.
+	err = utils.VerifyHash(h, b)
 	if err != nil {
 		return nil, err
 	}
-	if bytes.Compare(actualHash, h) != 0 {
-		return nil, fmt.Errorf("mismatching hashes: wanted:%q got:%q", h.String(), actualHash.String())
-	}
 	return b, nil
 }
 
+func (s Store) Has(ctx context.Context, h multihash.Multihash) (bool, error) {
+	return s.Inner.Has(ctx, h.HexString())
+}
+
+func (s Store) Stat(ctx context.Context, h multihash.Multihash) (datastore.Info, error) {
+	return s.Inner.Stat(ctx, h.HexString())
+}
+
+// Touch marks the object as recently written, so that garbage collection keeps it for its grace
+// period, or returns datastore.ErrNotFound.
+func (s Store) Touch(ctx context.Context, h multihash.Multihash) error {
+	return s.Inner.Touch(ctx, h.HexString())
+}
+
+func (s Store) Delete(ctx context.Context, h multihash.Multihash) error {
+	return s.Inner.Delete(ctx, h.HexString())
+}
+
+// List returns, in lexicographic order of their hex encoding, up to limit hashes of objects
+// strictly greater than after, which may be nil to start from the beginning. Entries in the
+// underlying DataStore that are not valid hashes are skipped.
+func (s Store) List(ctx context.Context, after multihash.Multihash, limit int) ([]multihash.Multihash, error) {
+	names, err := s.Inner.List(ctx, "", after.HexString(), limit)
+	if err != nil {
+		return nil, err
+	}
+	hs := []multihash.Multihash{}
+	for _, name := range names {
+		h, err := multihash.FromHexString(name)
+		if err != nil {
+			continue
+		}
+		hs = append(hs, h)
+	}
+	return hs, nil
+}
+
+// Add stores b under its hash, computed with utils.DefaultHashType.
 func (s Store) Add(ctx context.Context, b []byte) (multihash.Multihash, error) {
+	return s.AddWithHashType(ctx, utils.DefaultHashType, b)
+}
+
+// AddWithHashType is like Add, but hashes b with the given multihash function.
+func (s Store) AddWithHashType(ctx context.Context, hashType uint64, b []byte) (multihash.Multihash, error) {
 	h, err := multihash.Sum(b, hashType, -1)
 	if err != nil {
 		return nil, err
@@ -41,3 +80,45 @@ func (s Store) Add(ctx context.Context, b []byte) (multihash.Multihash, error) {
 	}
 	return h, nil
 }
+
+// GetReader is like Get, but streams the object. The hash is verified incrementally, and a mismatch
+// is reported by the final Read call.
+func (s Store) GetReader(ctx context.Context, h multihash.Multihash) (io.ReadCloser, error) {
+	rc, err := s.Inner.GetReader(ctx, h.HexString())
+	if err != nil {
+		return nil, err
+	}
+	return NewVerifyingReader(rc, h)
+}
+
+// Put is like Add, but streams the object from r, hashing it on the fly.
+func (s Store) Put(ctx context.Context, r io.Reader) (multihash.Multihash, error) {
+	return s.PutWithHashType(ctx, utils.DefaultHashType, r)
+}
+
+// PutWithHashType is like Put, but hashes the object with the given multihash function.
+func (s Store) PutWithHashType(ctx context.Context, hashType uint64, r io.Reader) (multihash.Multihash, error) {
+	w, err := s.Inner.NewWriter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+	hasher, err := multihash.GetHasher(hashType)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(io.MultiWriter(w, hasher), r)
+	if err != nil {
+		return nil, err
+	}
+	b, err := multihash.Encode(hasher.Sum(nil), hashType)
+	if err != nil {
+		return nil, err
+	}
+	h := multihash.Multihash(b)
+	err = w.Commit(h.HexString())
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
@@ -0,0 +1,50 @@
+package objectstore
+
+import (
+	"bytes"
+	"hash"
+	"io"
+
+	"github.com/google/ent/utils"
+	"github.com/multiformats/go-multihash"
+)
+
+type verifyingReader struct {
+	inner  io.ReadCloser
+	hasher hash.Hash
+	want   multihash.Multihash
+}
+
+// NewVerifyingReader wraps r so that its content is hashed as it is read. Once r is exhausted, the
+// final Read returns an error instead of io.EOF if the content does not match h, which also
+// determines the hash function.
+func NewVerifyingReader(r io.ReadCloser, h multihash.Multihash) (io.ReadCloser, error) {
+	hasher, _, err := utils.NewHasher(h)
+	if err != nil {
+		return nil, err
+	}
+	return &verifyingReader{
+		inner:  r,
+		hasher: hasher,
+		want:   h,
+	}, nil
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.inner.Read(p)
+	r.hasher.Write(p[:n])
+	if err == io.EOF {
+		actualHash, encodeErr := utils.EncodeDigest(r.hasher.Sum(nil), r.want)
+		if encodeErr != nil {
+			return n, encodeErr
+		}
+		if bytes.Compare(actualHash, r.want) != 0 {
+			return n, &utils.HashMismatchError{Expected: r.want.String(), Actual: actualHash.String()}
+		}
+	}
+	return n, err
+}
+
+func (r *verifyingReader) Close() error {
+	return r.inner.Close()
+}
@@ -0,0 +1,1026 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/ent/gc"
+	"github.com/google/ent/tagstore"
+	"github.com/google/ent/translog"
+	"github.com/google/ent/utils"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/multiformats/go-multihash"
+)
+
+// ListTagsResponse lists the names of the tags matching the request, except reserved ones.
+type ListTagsResponse struct {
+	Tags []string
+}
+
+// listTagsHandler lists the tags whose name starts with the "prefix" query parameter, in
+// lexicographic order, starting after the "after" query parameter. At most "limit" names are
+// returned, and never more than tagstore.ListPageSize.
+func (s *Server) listTagsHandler(c *gin.Context) {
+	limit := tagstore.ListPageSize
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			log.Printf("invalid limit %q", l)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		if n < limit {
+			limit = n
+		}
+	}
+	tags, err := s.TagStore.List(c, c.Query("prefix"), c.Query("after"), limit)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, ListTagsResponse{
+		Tags: tags,
+	})
+}
+
+func (s *Server) getTagHandler(c *gin.Context) {
+	tagName := c.Param("name")
+	tagValue, err := s.TagStore.Get(c, tagName)
+	if err == tagstore.ErrNotFound {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	} else if errors.Is(err, tagstore.ErrInvalidName) {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Header("ETag", tagstore.ETag(tagValue))
+	c.Data(http.StatusOK, "text/plain", tagValue)
+}
+
+// TagHistoryEntry records a single update to a tag; Previous is empty if the tag did not exist,
+// and Value is empty if the tag was deleted.
+type TagHistoryEntry struct {
+	Time     time.Time
+	Previous string
+	Value    string
+}
+
+func (s *Server) getTagHistoryHandler(c *gin.Context) {
+	tagName := c.Param("name")
+	history, err := s.TagStore.History(c, tagName)
+	if errors.Is(err, tagstore.ErrInvalidName) {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	res := []TagHistoryEntry{}
+	for _, e := range history {
+		res = append(res, TagHistoryEntry{
+			Time:     e.Time,
+			Previous: string(e.Previous),
+			Value:    string(e.Value),
+		})
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// postTagHandler sets the tag to the value in the request body, which is either a CID or a
+// JSON-encoded tagstore.SignedTag. Signed values must have a valid signature for the tag, and a
+// sequence number greater than that of the current value, if signed; otherwise the update fails with
+// 409 Conflict. Whether the key that signed the value is trusted is up to clients to decide.
+//
+// The update is conditional if the request has an If-Match header with the ETag of the expected
+// current value, or an If-None-Match header set to "*" to only create the tag if it does not exist
+// yet; if the condition is not met, it fails with 412 Precondition Failed.
+func (s *Server) postTagHandler(c *gin.Context) {
+	tagName := c.Param("name")
+	if err := tagstore.ValidateName(tagName); err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if !s.canWriteTag(c, tagName) {
+		log.Printf("not allowed to write tag %q", tagName)
+		s.abortUnauthorized(c)
+		return
+	}
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	_, signed, err := tagstore.ParseValue(body)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	var value []byte
+	if signed != nil {
+		err = signed.Verify(tagName)
+		if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		if _, err := cid.Decode(signed.Value); err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		value, err = signed.Marshal()
+		if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	} else {
+		tagValue, err := cid.Decode(string(body))
+		if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		value = []byte(tagValue.String())
+	}
+
+	current, err := s.TagStore.Get(c, tagName)
+	if err == tagstore.ErrNotFound {
+		current = nil
+	} else if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	ifMatch := c.GetHeader("If-Match")
+	ifNoneMatch := c.GetHeader("If-None-Match") == "*"
+	if (ifNoneMatch && current != nil) || (ifMatch != "" && (current == nil || ifMatch != tagstore.ETag(current))) {
+		log.Printf("precondition failed for tag %q", tagName)
+		if current != nil {
+			c.Header("ETag", tagstore.ETag(current))
+		}
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	}
+	if signed != nil && current != nil {
+		_, currentSigned, err := tagstore.ParseValue(current)
+		if err == nil && currentSigned != nil && signed.Seq <= currentSigned.Seq {
+			log.Printf("tag %q: sequence number %d is not greater than current %d", tagName, signed.Seq, currentSigned.Seq)
+			c.AbortWithStatus(http.StatusConflict)
+			return
+		}
+	}
+
+	update := func() error {
+		if ifMatch != "" || ifNoneMatch || signed != nil {
+			// Make sure that the checks above still hold when the tag is updated.
+			return s.TagStore.CompareAndSet(c, tagName, current, value)
+		}
+		return s.TagStore.Set(c, tagName, value)
+	}
+	if s.Log != nil {
+		// Only updates that succeed are logged.
+		err = s.Log.Append(c, tagName, value, update)
+	} else {
+		err = update()
+	}
+	if conflict, ok := err.(*tagstore.ConflictError); ok {
+		log.Print(conflict)
+		if conflict.Actual != nil {
+			c.Header("ETag", tagstore.ETag(conflict.Actual))
+		}
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	} else if errors.Is(err, tagstore.ErrInvalidName) {
+		// For instance, the store does not support a tag with the same name as a namespace.
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Header("ETag", tagstore.ETag(value))
+	c.Status(http.StatusOK)
+}
+
+// deleteTagHandler deletes a tag, only if its current value matches the If-Match header, if any.
+func (s *Server) deleteTagHandler(c *gin.Context) {
+	tagName := c.Param("name")
+	if err := tagstore.ValidateName(tagName); err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if !s.canWriteTag(c, tagName) {
+		log.Printf("not allowed to delete tag %q", tagName)
+		s.abortUnauthorized(c)
+		return
+	}
+	current, err := s.TagStore.Get(c, tagName)
+	if err == tagstore.ErrNotFound {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch != "" && ifMatch != tagstore.ETag(current) {
+		log.Printf("precondition failed for tag %q", tagName)
+		c.Header("ETag", tagstore.ETag(current))
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	}
+
+	remove := func() error {
+		return s.TagStore.Delete(c, tagName)
+	}
+	if s.Log != nil {
+		// Deletions are logged as updates to an empty value.
+		err = s.Log.Append(c, tagName, nil, remove)
+	} else {
+		err = remove()
+	}
+	if err == tagstore.ErrNotFound {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type RenameRequest struct {
+	Root     string
+	FromPath string
+	ToPath   string
+	// Overwrite allows replacing an existing node at ToPath.
+	Overwrite bool
+}
+
+type RemoveRequest struct {
+	Root string
+	Path string
+}
+
+type UploadRequest struct {
+	Root  string
+	Blobs []UploadBlob
+}
+
+type UploadBlob struct {
+	Type    string // file | directory
+	Path    string
+	Content []byte
+}
+
+type UploadResponse struct {
+	Root string
+}
+
+type GCRequest struct {
+	// Pins are additional roots to keep, besides all the tags.
+	Pins   []string
+	DryRun bool
+	// GracePeriod is parsed by time.ParseDuration; defaults to DefaultGCGracePeriod, and must not
+	// be shorter than MinGCGracePeriod.
+	GracePeriod string
+	// History is parsed by time.ParseDuration; the values that tags had within that long ago are
+	// kept as well. Defaults to gc.DefaultHistoryRetention.
+	History string
+}
+
+// DefaultGCGracePeriod is the grace period of garbage collection requests that do not specify one.
+const DefaultGCGracePeriod = time.Hour
+
+// MinGCGracePeriod is the shortest grace period accepted by the server, so that a garbage
+// collection request cannot delete the objects of uploads in progress.
+const MinGCGracePeriod = 10 * time.Minute
+
+type GCResponse struct {
+	Reachable  int
+	Missing    int
+	Kept       int
+	Swept      []string
+	SweptBytes int64
+}
+
+type GetRequest struct {
+	Root string
+	Path string
+}
+
+type GetResponse struct {
+	Content []byte
+}
+
+type GetManyRequest struct {
+	Roots []string
+}
+
+// GetManyResponse maps each node id to its content; nodes that were not found are omitted.
+type GetManyResponse struct {
+	Contents map[string][]byte
+}
+
+type ObjectsMissingRequest struct {
+	Hashes []string
+}
+
+type ObjectsMissingResponse struct {
+	Missing []string
+}
+
+// ObjectsUpdateResponse contains the hashes of objects uploaded in a batch, in the same order as
+// they were sent.
+type ObjectsUpdateResponse struct {
+	Hashes []string
+}
+
+// DefaultMaxBatchSize is the maximum number of items accepted by batch endpoints in a single
+// request, unless overridden by Server.MaxBatchSize.
+const DefaultMaxBatchSize = 1000
+
+// DefaultMaxBatchBytes is the maximum size of the body of a batch upload request, unless
+// overridden by Server.MaxBatchBytes.
+const DefaultMaxBatchBytes = 64 << 20
+
+func (s *Server) apiUpdateHandler(c *gin.Context) {
+	var req UploadRequest
+	json.NewDecoder(c.Request.Body).Decode(&req)
+
+	if req.Root == "" && len(req.Blobs) == 1 {
+		log.Printf("individual blob")
+		// Individual blob upload.
+		b := req.Blobs[0]
+		var node format.Node
+		var err error
+		switch b.Type {
+		case "file":
+			node, err = utils.ParseRawNode(b.Content)
+			if err != nil {
+				log.Print(err)
+				c.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+		case "directory":
+			node, err = utils.ParseProtoNode(b.Content)
+			if err != nil {
+				log.Print(err)
+				c.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+		default:
+			log.Printf("invalid type: %s", b.Type)
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if node == nil {
+			log.Print("invalid cid")
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		err = s.blobStore.Add(c, node)
+		if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		log.Printf("uploaded: %s", node.Cid().String())
+		c.JSON(http.StatusOK, UploadResponse{
+			Root: node.Cid().String(),
+		})
+		return
+	}
+
+	root, err := cid.Decode(req.Root)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	for _, b := range req.Blobs {
+		log.Printf("type: %s", b.Type)
+		log.Printf("path: %s", b.Path)
+		pathSegments := parsePath(b.Path)
+		log.Printf("path segments: %#v", pathSegments)
+		var newNode format.Node
+		switch b.Type {
+		case "file":
+			newNode, err = utils.ParseRawNode(b.Content)
+			if err != nil {
+				log.Print(err)
+				c.AbortWithStatus(http.StatusNotFound)
+				return
+			}
+		case "directory":
+			newNode = utils.NewProtoNode()
+		default:
+			log.Printf("invalid type: %s", b.Type)
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		err := s.blobStore.Add(c, newNode)
+		if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		log.Printf("new hash: %s", newNode.Cid().String())
+		root, err = s.traverseAdd(c, root, pathSegments, newNode.Cid(), utils.Metadata{})
+		if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+	}
+	res := UploadResponse{
+		Root: root.String(),
+	}
+	log.Printf("res: %#v", res)
+	c.JSON(http.StatusOK, res)
+}
+
+func (s *Server) apiRenameHandler(c *gin.Context) {
+	var r RenameRequest
+	json.NewDecoder(c.Request.Body).Decode(&r)
+	log.Printf("rename: %#v", r)
+	root, err := cid.Decode(r.Root)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	fromSegments := parsePath(r.FromPath)
+	toSegments := parsePath(r.ToPath)
+	if len(fromSegments) == 0 || len(toSegments) == 0 {
+		log.Printf("cannot rename root")
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if isPrefix(fromSegments, toSegments) {
+		log.Printf("cannot move %q into itself", r.FromPath)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	target, err := s.traverse(c, root, fromSegments)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	// The entry keeps its metadata, e.g. its mode or whether it is a symbolic link, under its new
+	// name.
+	metadata, err := s.entryMetadata(c, root, fromSegments)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	_, err = s.traverse(c, root, toSegments)
+	if err == nil && !r.Overwrite {
+		log.Printf("target path %q already exists", r.ToPath)
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	}
+
+	root, err = s.traverseRemove(c, root, fromSegments)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	root, err = s.traverseAdd(c, root, toSegments, target, metadata)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	res := UploadResponse{
+		Root: root.String(),
+	}
+	log.Printf("res: %#v", res)
+	c.JSON(http.StatusOK, res)
+}
+
+// entryMetadata returns the metadata of the entry at the given path, which must not be empty, as
+// recorded by its parent directory.
+func (s *Server) entryMetadata(c context.Context, root cid.Cid, segments []string) (utils.Metadata, error) {
+	parent, err := s.traverse(c, root, segments[:len(segments)-1])
+	if err != nil {
+		return utils.Metadata{}, err
+	}
+	node, err := s.blobStore.Get(c, parent)
+	if err != nil {
+		return utils.Metadata{}, fmt.Errorf("could not get blob %s", parent)
+	}
+	dir, ok := node.(*merkledag.ProtoNode)
+	if !ok {
+		return utils.Metadata{}, fmt.Errorf("incorrect node type")
+	}
+	metadata, err := utils.GetMetadata(dir)
+	if err != nil {
+		return utils.Metadata{}, err
+	}
+	return metadata[segments[len(segments)-1]], nil
+}
+
+// isPrefix returns whether prefix is equal to, or an ancestor of, segments.
+func isPrefix(prefix []string, segments []string) bool {
+	if len(prefix) > len(segments) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) apiRemoveHandler(c *gin.Context) {
+	var req RemoveRequest
+	json.NewDecoder(c.Request.Body).Decode(&req)
+	log.Printf("req: %#v", req)
+	root, err := cid.Decode(req.Root)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	pathSegments := parsePath(req.Path)
+	hash, err := s.traverseRemove(c, root, pathSegments)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	res := UploadResponse{
+		Root: hash.String(),
+	}
+	log.Printf("res: %#v", res)
+	c.JSON(http.StatusOK, res)
+}
+
+func (s *Server) apiObjectsGetHandler(c *gin.Context) {
+	s.serveObject(c, c.Param("objecthash"))
+}
+
+// rootObjectsGetHandler serves GET requests for /<objecthash>, and 404 for anything else.
+func (s *Server) rootObjectsGetHandler(c *gin.Context) {
+	if c.Request.Method != http.MethodGet || strings.Count(c.Request.URL.Path, "/") != 1 {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	s.serveObject(c, strings.TrimPrefix(c.Request.URL.Path, "/"))
+}
+
+func (s *Server) serveObject(c *gin.Context, hashString string) {
+	hash, err := utils.ParseHash(hashString)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	// Objects are verified with the hash function of their multihash, which must be supported.
+	_, _, err = utils.NewHasher(hash)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	object, err := s.blobStore.GetObjectReader(c, hash)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	defer object.Close()
+	// A hash mismatch is only detected once the whole object has been streamed, at which point
+	// the status code has already been sent; clients are expected to verify the hash themselves.
+	c.DataFromReader(http.StatusOK, -1, "application/octet-stream", object, nil)
+}
+
+// uploadHashType returns the multihash function requested by the "hash" query parameter of an
+// upload, e.g. "blake3", defaulting to utils.DefaultHashType.
+func uploadHashType(c *gin.Context) (uint64, error) {
+	name := c.Query("hash")
+	if name == "" {
+		return utils.DefaultHashType, nil
+	}
+	return utils.ParseHashType(name)
+}
+
+func (s *Server) apiObjectsUpdateHandler(c *gin.Context) {
+	hashType, err := uploadHashType(c)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	hash, err := s.ObjectStore.PutWithHashType(c, hashType, c.Request.Body)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.String(http.StatusOK, hash.HexString())
+}
+
+func (s *Server) apiObjectsMissingHandler(c *gin.Context) {
+	var req ObjectsMissingRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&req)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if len(req.Hashes) > s.maxBatchSize() {
+		log.Printf("too many hashes: %d", len(req.Hashes))
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	hashes := []multihash.Multihash{}
+	for _, h := range req.Hashes {
+		hash, err := utils.ParseHash(h)
+		if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		hashes = append(hashes, hash)
+	}
+	missing, err := s.blobStore.MissingObjects(c, hashes)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	res := ObjectsMissingResponse{
+		Missing: []string{},
+	}
+	for _, h := range missing {
+		res.Missing = append(res.Missing, h.HexString())
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// apiObjectsBatchUpdateHandler accepts a sequence of objects, each prefixed by its length as an
+// unsigned varint.
+func (s *Server) apiObjectsBatchUpdateHandler(c *gin.Context) {
+	hashType, err := uploadHashType(c)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	body := bufio.NewReader(http.MaxBytesReader(c.Writer, c.Request.Body, s.maxBatchBytes()))
+	objects := [][]byte{}
+	for {
+		object, err := utils.ReadLengthPrefixed(body, uint64(s.maxBatchBytes()))
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		if len(objects) == s.maxBatchSize() {
+			log.Printf("too many objects")
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		objects = append(objects, object)
+	}
+
+	res := ObjectsUpdateResponse{
+		Hashes: []string{},
+	}
+	for _, object := range objects {
+		h, err := s.ObjectStore.AddWithHashType(c, hashType, object)
+		if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		res.Hashes = append(res.Hashes, h.HexString())
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (s *Server) apiAdminGCHandler(c *gin.Context) {
+	var req GCRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&req)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	gracePeriod := DefaultGCGracePeriod
+	if req.GracePeriod != "" {
+		gracePeriod, err = time.ParseDuration(req.GracePeriod)
+		if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+	if gracePeriod < MinGCGracePeriod {
+		log.Printf("grace period %v is shorter than %v", gracePeriod, MinGCGracePeriod)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	history := gc.DefaultHistoryRetention
+	if req.History != "" {
+		history, err = time.ParseDuration(req.History)
+		if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	roots := []cid.Cid{}
+	for _, p := range req.Pins {
+		root, err := cid.Decode(p)
+		if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		roots = append(roots, root)
+	}
+	tagRoots, err := gc.TagRoots(c, s.blobStore, s.TagStore, []string{translog.HeadTag}, history)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	roots = append(roots, tagRoots...)
+
+	report, err := gc.Run(c, s.blobStore, s.ObjectStore, gc.Options{
+		Roots:       roots,
+		GracePeriod: gracePeriod,
+		DryRun:      req.DryRun,
+	})
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	res := GCResponse{
+		Reachable:  report.Reachable,
+		Missing:    report.Missing,
+		Kept:       report.Kept,
+		Swept:      []string{},
+		SweptBytes: report.SweptBytes,
+	}
+	for _, o := range report.Swept {
+		res.Swept = append(res.Swept, o.Hash.HexString())
+	}
+	log.Printf("gc: %#v", res)
+	c.JSON(http.StatusOK, res)
+}
+
+func (s *Server) apiGetHandler(c *gin.Context) {
+	var req GetRequest
+	json.NewDecoder(c.Request.Body).Decode(&req)
+	log.Printf("req: %#v", req)
+
+	root, err := cid.Decode(req.Root)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	segments := parsePath(req.Path)
+	target, err := s.traverse(c, root, segments)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	node, err := s.blobStore.Get(c, target)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	res := GetResponse{
+		Content: node.RawData(),
+	}
+	log.Printf("res: %#v", res)
+	c.JSON(http.StatusOK, res)
+}
+
+func (s *Server) apiGetManyHandler(c *gin.Context) {
+	var req GetManyRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&req)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if len(req.Roots) > s.maxBatchSize() {
+		log.Printf("too many roots: %d", len(req.Roots))
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	roots := []cid.Cid{}
+	for _, r := range req.Roots {
+		root, err := cid.Decode(r)
+		if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		roots = append(roots, root)
+	}
+	res := GetManyResponse{
+		Contents: map[string][]byte{},
+	}
+	for o := range s.blobStore.GetMany(c, roots) {
+		if o.Err != nil {
+			log.Print(o.Err)
+			continue
+		}
+		res.Contents[o.Node.Cid().String()] = o.Node.RawData()
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (s *Server) traverse(c context.Context, root cid.Cid, segments []string) (cid.Cid, error) {
+	if len(segments) == 0 {
+		return root, nil
+	} else {
+		node, err := s.blobStore.Get(c, root)
+		if err != nil {
+			return cid.Undef, fmt.Errorf("could not get blob %s", root)
+		}
+		switch node := node.(type) {
+		case *merkledag.ProtoNode:
+			if utils.IsChunkedFile(node) {
+				return cid.Undef, fmt.Errorf("%s is not a directory", root)
+			}
+			head := segments[0]
+			next, err := utils.GetLink(node, head)
+			if err != nil {
+				return cid.Undef, fmt.Errorf("could not traverse %s/%s: %v", root, head, err)
+			}
+			log.Printf("next: %v", next)
+			return s.traverse(c, next, segments[1:])
+		default:
+			return cid.Undef, fmt.Errorf("incorrect node type")
+		}
+	}
+}
+
+// traverseAdd sets the entry at the given path to nodeToAdd, with metadata m, creating
+// intermediate directories as needed, and returns the new root.
+func (s *Server) traverseAdd(c context.Context, root cid.Cid, segments []string, nodeToAdd cid.Cid, m utils.Metadata) (cid.Cid, error) {
+	log.Printf("traverseAdd %v/%#v", root, segments)
+	if len(segments) == 0 {
+		return nodeToAdd, nil
+	} else {
+		node, err := s.blobStore.Get(c, root)
+		if err != nil {
+			return cid.Undef, fmt.Errorf("could not get blob %s", root)
+		}
+		switch node := node.(type) {
+		case *merkledag.ProtoNode:
+			if utils.IsChunkedFile(node) {
+				return cid.Undef, fmt.Errorf("%s is not a directory", root)
+			}
+			head := segments[0]
+			var next cid.Cid
+			next, err = utils.GetLink(node, head)
+			if err == merkledag.ErrLinkNotFound {
+				// Create intermediate directories as needed.
+				newNode := utils.NewProtoNode()
+				err = s.blobStore.Add(c, newNode)
+				if err != nil {
+					return cid.Undef, fmt.Errorf("could not add node: %v", err)
+				}
+				next = newNode.Cid()
+			} else if err != nil {
+				return cid.Undef, fmt.Errorf("could not get link: %v", err)
+			}
+			log.Printf("next: %v", next)
+
+			newHash, err := s.traverseAdd(c, next, segments[1:], nodeToAdd, m)
+			if err != nil {
+				return cid.Undef, fmt.Errorf("could not call recursively: %v", err)
+			}
+
+			if len(segments) == 1 {
+				err = utils.SetLink(node, head, newHash)
+				if err == nil {
+					err = utils.SetMetadata(node, head, m)
+				}
+			} else {
+				err = utils.UpdateLink(node, head, newHash)
+			}
+			if err != nil {
+				return cid.Undef, fmt.Errorf("could not add link: %v", err)
+			}
+			return node.Cid(), s.blobStore.Add(c, node)
+		default:
+			return cid.Undef, fmt.Errorf("incorrect node type")
+		}
+	}
+}
+
+func (s *Server) traverseRemove(c context.Context, root cid.Cid, segments []string) (cid.Cid, error) {
+	log.Printf("traverseRemove %v/%#v", root, segments)
+	node, err := s.blobStore.Get(c, root)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("could not get node %s", root)
+	}
+	switch node := node.(type) {
+	case *merkledag.ProtoNode:
+		if utils.IsChunkedFile(node) {
+			return cid.Undef, fmt.Errorf("%s is not a directory", root)
+		}
+		if len(segments) == 1 {
+			utils.RemoveLink(node, segments[0])
+		} else {
+			head := segments[0]
+			var next cid.Cid
+			next, err = utils.GetLink(node, head)
+			if err == merkledag.ErrLinkNotFound {
+				// Ok
+				newNode := utils.NewProtoNode()
+				err = s.blobStore.Add(c, newNode)
+				// TODO
+				next = newNode.Cid()
+
+			} else if err != nil {
+				return cid.Undef, fmt.Errorf("could not get link: %v", err)
+			}
+			log.Printf("next: %v", next)
+
+			newHash, err := s.traverseRemove(c, next, segments[1:])
+			if err != nil {
+				return cid.Undef, fmt.Errorf("could not call recursively: %v", err)
+			}
+
+			err = utils.UpdateLink(node, head, newHash)
+			if err != nil {
+				return cid.Undef, fmt.Errorf("could not add link: %v", err)
+			}
+		}
+		return node.Cid(), s.blobStore.Add(c, node)
+	default:
+		return cid.Undef, nil
+	}
+}
@@ -0,0 +1,273 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/ent/nodeservice"
+	"github.com/google/ent/utils"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+)
+
+// archiveModTime is the modification time of all archive entries, so that archives of the same
+// node are byte-identical. It is the earliest time that can be represented in zip files.
+var archiveModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// archiveWriter writes the entries of an archive, in the order in which they are added.
+type archiveWriter interface {
+	addDir(name string, mode os.FileMode) error
+	addFile(name string, mode os.FileMode, size int64, r io.Reader) error
+	addSymlink(name string, target string) error
+	Close() error
+}
+
+type tarWriter struct {
+	tw *tar.Writer
+	// gw, if not nil, compresses the output of tw.
+	gw *gzip.Writer
+}
+
+func (w tarWriter) addDir(name string, mode os.FileMode) error {
+	return w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     int64(mode),
+		ModTime:  archiveModTime,
+		Format:   tar.FormatPAX,
+	})
+}
+
+func (w tarWriter) addFile(name string, mode os.FileMode, size int64, r io.Reader) error {
+	err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     int64(mode),
+		ModTime:  archiveModTime,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w.tw, r)
+	return err
+}
+
+func (w tarWriter) addSymlink(name string, target string) error {
+	return w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     name,
+		Linkname: target,
+		Mode:     0777,
+		ModTime:  archiveModTime,
+		Format:   tar.FormatPAX,
+	})
+}
+
+func (w tarWriter) Close() error {
+	err := w.tw.Close()
+	if err != nil {
+		return err
+	}
+	if w.gw != nil {
+		return w.gw.Close()
+	}
+	return nil
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w zipWriter) addDir(name string, mode os.FileMode) error {
+	h := &zip.FileHeader{
+		Name:     name + "/",
+		Modified: archiveModTime,
+	}
+	h.SetMode(os.ModeDir | mode)
+	_, err := w.zw.CreateHeader(h)
+	return err
+}
+
+func (w zipWriter) addFile(name string, mode os.FileMode, size int64, r io.Reader) error {
+	h := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: archiveModTime,
+	}
+	h.SetMode(mode)
+	fw, err := w.zw.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+// addSymlink stores the target of the link as its content, as the zip command does.
+func (w zipWriter) addSymlink(name string, target string) error {
+	h := &zip.FileHeader{
+		Name:     name,
+		Modified: archiveModTime,
+	}
+	h.SetMode(os.ModeSymlink | 0777)
+	fw, err := w.zw.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(fw, target)
+	return err
+}
+
+func (w zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+// serveArchive streams an archive of the directory node, in the given format (tar, tar.gz or zip).
+// All entries are under a top-level directory with the given name.
+func (s *Server) serveArchive(c *gin.Context, target cid.Cid, node format.Node, name string, archiveFormat string) {
+	dir, ok := node.(*merkledag.ProtoNode)
+	if !ok || utils.IsChunkedFile(dir) {
+		log.Printf("%s is not a directory", target)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	// Entries of nested directories can only be checked while the archive is being written.
+	err := utils.CheckLinks(dir)
+	if err != nil {
+		log.Printf("invalid directory %s: %v", target, err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if utils.CheckLinkName(name) != nil {
+		name = target.String()
+	}
+	var w archiveWriter
+	var contentType string
+	switch archiveFormat {
+	case "tar":
+		w = tarWriter{
+			tw: tar.NewWriter(c.Writer),
+		}
+		contentType = "application/x-tar"
+	case "tar.gz":
+		gw := gzip.NewWriter(c.Writer)
+		w = tarWriter{
+			tw: tar.NewWriter(gw),
+			gw: gw,
+		}
+		contentType = "application/gzip"
+	case "zip":
+		w = zipWriter{
+			zw: zip.NewWriter(c.Writer),
+		}
+		contentType = "application/zip"
+	default:
+		log.Printf("invalid archive format: %q", archiveFormat)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+archiveFormat))
+	c.Header("ent-hash", target.String())
+	c.Status(http.StatusOK)
+	// Errors after this point can only be detected by clients as a truncated archive.
+	err = s.writeArchiveDir(c, w, name, utils.DefaultDirMode, dir)
+	if err != nil {
+		log.Printf("could not write archive of %s: %v", target, err)
+		return
+	}
+	err = w.Close()
+	if err != nil {
+		log.Printf("could not write archive of %s: %v", target, err)
+	}
+}
+
+// writeArchiveDir adds the directory and, recursively, all its entries to the archive, in the
+// order of the links of the node, which are sorted by name, and with the modes recorded in the
+// node. Directories with entry names that would escape the archive, such as "..", are rejected.
+func (s *Server) writeArchiveDir(ctx context.Context, w archiveWriter, name string, mode os.FileMode, dir *merkledag.ProtoNode) error {
+	err := utils.CheckLinks(dir)
+	if err != nil {
+		return err
+	}
+	err = w.addDir(name, mode)
+	if err != nil {
+		return err
+	}
+	metadata, err := utils.GetMetadata(dir)
+	if err != nil {
+		return err
+	}
+	for _, l := range dir.Links() {
+		entryName := path.Join(name, l.Name)
+		m := metadata[l.Name]
+		node, err := s.blobStore.Get(ctx, l.Cid)
+		if err != nil {
+			return fmt.Errorf("could not get node %s: %v", l.Cid, err)
+		}
+		switch node := node.(type) {
+		case *merkledag.ProtoNode:
+			if utils.IsChunkedFile(node) {
+				r, err := nodeservice.NewFileReader(ctx, s.blobStore, l.Cid)
+				if err != nil {
+					return err
+				}
+				err = w.addFile(entryName, m.FileMode(false), int64(utils.FileSize(node)), r)
+				r.Close()
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			err := s.writeArchiveDir(ctx, w, entryName, m.FileMode(true), node)
+			if err != nil {
+				return err
+			}
+		case *merkledag.RawNode:
+			if m.Symlink {
+				err := w.addSymlink(entryName, string(node.RawData()))
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			err := w.addFile(entryName, m.FileMode(false), int64(len(node.RawData())), bytes.NewReader(node.RawData()))
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported node %s", l.Cid)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ScopeObjectsRead allows reading objects, nodes and tags.
+	ScopeObjectsRead = "objects:read"
+	// ScopeObjectsWrite allows uploading objects and nodes, including via /api/update,
+	// /api/rename and /api/remove, which only create new nodes.
+	ScopeObjectsWrite = "objects:write"
+	// ScopeTagsWritePrefix, followed by a namespace ending in "/", allows setting the tags in that
+	// namespace, e.g. "tags:write:team/" allows "team/app" but not "teammate"; on its own, it
+	// allows setting any tag.
+	ScopeTagsWritePrefix = "tags:write:"
+	// ScopeAdmin allows running administrative operations such as garbage collection.
+	ScopeAdmin = "admin"
+)
+
+// scopesKey is the key of the scopes granted to the current request in the gin context.
+const scopesKey = "scopes"
+
+// TokensFile is the format of the server-side file listing the tokens accepted by the server, e.g.:
+//
+//	anonymous_scopes = ["objects:read"]
+//
+//	[[tokens]]
+//	name = "ci"
+//	token = "some-long-random-string"
+//	scopes = ["objects:read", "objects:write", "tags:write:ci/"]
+type TokensFile struct {
+	// AnonymousScopes are granted to requests without a token.
+	AnonymousScopes []string `toml:"anonymous_scopes"`
+	Tokens          []Token  `toml:"tokens"`
+}
+
+type Token struct {
+	// Name identifies the token in logs.
+	Name   string   `toml:"name"`
+	Token  string   `toml:"token"`
+	Scopes []string `toml:"scopes"`
+}
+
+// Auth authenticates requests via bearer tokens, and determines the scopes granted to them.
+type Auth struct {
+	anonymousScopes []string
+	// tokens is keyed by the SHA-256 hash of each token, so that looking up a token does not
+	// leak its value through timing.
+	tokens map[[sha256.Size]byte]Token
+}
+
+// ParseTokens parses and validates the tokens file with the given name.
+func ParseTokens(filename string) (*Auth, error) {
+	var tokensFile TokensFile
+
+	f, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	md, err := toml.Decode(string(f), &tokensFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", filename, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := []string{}
+		for _, k := range undecoded {
+			keys = append(keys, k.String())
+		}
+		return nil, fmt.Errorf("unknown keys in %s: %s", filename, strings.Join(keys, ", "))
+	}
+
+	auth := &Auth{
+		anonymousScopes: tokensFile.AnonymousScopes,
+		tokens:          map[[sha256.Size]byte]Token{},
+	}
+	err = validateScopes(tokensFile.AnonymousScopes)
+	if err != nil {
+		return nil, fmt.Errorf("anonymous scopes: %v", err)
+	}
+	for _, t := range tokensFile.Tokens {
+		if t.Name == "" || t.Token == "" {
+			return nil, fmt.Errorf("tokens must have a name and a token")
+		}
+		err := validateScopes(t.Scopes)
+		if err != nil {
+			return nil, fmt.Errorf("token %q: %v", t.Name, err)
+		}
+		h := sha256.Sum256([]byte(t.Token))
+		if _, ok := auth.tokens[h]; ok {
+			return nil, fmt.Errorf("token %q: duplicate token", t.Name)
+		}
+		auth.tokens[h] = t
+	}
+	return auth, nil
+}
+
+func validateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		switch {
+		case scope == ScopeObjectsRead, scope == ScopeObjectsWrite, scope == ScopeAdmin:
+		case strings.HasPrefix(scope, ScopeTagsWritePrefix):
+			// Namespaces must end in "/", so that they only match whole segments.
+			namespace := strings.TrimPrefix(scope, ScopeTagsWritePrefix)
+			if namespace != "" && !strings.HasSuffix(namespace, "/") {
+				return fmt.Errorf("invalid scope %q: the namespace must end with \"/\"", scope)
+			}
+		default:
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// authenticate checks the bearer token of the request, if any, and records the scopes granted to
+// it; requests with an invalid token are rejected.
+func (s *Server) authenticate(c *gin.Context) {
+	if s.Auth == nil {
+		return
+	}
+	header := c.GetHeader("Authorization")
+	if header == "" {
+		c.Set(scopesKey, s.Auth.anonymousScopes)
+		return
+	}
+	if !strings.HasPrefix(header, "Bearer ") {
+		log.Printf("invalid authorization header")
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	t, ok := s.Auth.tokens[sha256.Sum256([]byte(strings.TrimPrefix(header, "Bearer ")))]
+	if !ok {
+		log.Printf("invalid token")
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	log.Printf("token: %s", t.Name)
+	c.Set(scopesKey, t.Scopes)
+}
+
+// hasScope returns whether the request has been granted a scope for which f returns true.
+func (s *Server) hasScope(c *gin.Context, f func(scope string) bool) bool {
+	if s.Auth == nil {
+		return true
+	}
+	for _, scope := range c.GetStringSlice(scopesKey) {
+		if f(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// abortUnauthorized aborts a request that lacks a required scope, with 401 if it did not provide
+// a token, so that clients know to retry with one, or with 403 otherwise.
+func (s *Server) abortUnauthorized(c *gin.Context) {
+	if c.GetHeader("Authorization") == "" {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.AbortWithStatus(http.StatusUnauthorized)
+	} else {
+		c.AbortWithStatus(http.StatusForbidden)
+	}
+}
+
+// requireScope returns a handler that rejects requests that have not been granted the given scope.
+func (s *Server) requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.hasScope(c, func(granted string) bool { return granted == scope }) {
+			log.Printf("missing scope %q", scope)
+			s.abortUnauthorized(c)
+		}
+	}
+}
+
+// canWriteTag returns whether the request is allowed to set the given tag.
+func (s *Server) canWriteTag(c *gin.Context, name string) bool {
+	return s.hasScope(c, func(scope string) bool {
+		return strings.HasPrefix(scope, ScopeTagsWritePrefix) && strings.HasPrefix(name, strings.TrimPrefix(scope, ScopeTagsWritePrefix))
+	})
+}
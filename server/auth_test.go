@@ -0,0 +1,259 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/ent/datastore"
+	"github.com/google/ent/nodeservice"
+	"github.com/google/ent/objectstore"
+	"github.com/google/ent/tagstore"
+	"github.com/google/ent/translog"
+	"github.com/google/ent/utils"
+	"github.com/ipfs/go-cid"
+)
+
+const testTokens = `
+anonymous_scopes = []
+
+[[tokens]]
+name = "reader"
+token = "reader-token"
+scopes = ["objects:read"]
+
+[[tokens]]
+name = "writer"
+token = "writer-token"
+scopes = ["objects:write"]
+
+[[tokens]]
+name = "team"
+token = "team-token"
+scopes = ["tags:write:team/"]
+
+[[tokens]]
+name = "admin"
+token = "admin-token"
+scopes = ["admin"]
+`
+
+// newTestServer starts a server with file stores in a temporary directory, authenticating requests
+// with the given tokens file, and returns its URL and the CID of a node stored in it.
+func newTestServer(t *testing.T, tokens string) (string, cid.Cid) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	tokensFile := filepath.Join(dir, "tokens.toml")
+	err := ioutil.WriteFile(tokensFile, []byte(tokens), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := ParseTokens(tokensFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := objectstore.Store{
+		Inner: datastore.File{
+			DirName: t.TempDir(),
+		},
+	}
+	tags := tagstore.File{
+		DirName: t.TempDir(),
+	}
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		ObjectStore: objects,
+		TagStore:    tags,
+		Auth:        auth,
+		Log: &translog.Log{
+			Nodes: nodeservice.DataStore{Inner: objects},
+			Tags:  tags,
+			Key:   key,
+		},
+	}
+	node, err := utils.ParseRawNode([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = nodeservice.DataStore{Inner: objects}.Add(context.Background(), node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return ts.URL, node.Cid()
+}
+
+type authTestRoute struct {
+	method string
+	path   string
+	body   string
+	// scope is the scope required by the route; for tag writes, it is the name of the tag.
+	scope string
+	tag   bool
+}
+
+func authTestRoutes(c cid.Cid) []authTestRoute {
+	root := c.String()
+	hash := utils.Hash(c)
+	return []authTestRoute{
+		{method: "GET", path: "/api/objects/" + hash, scope: ScopeObjectsRead},
+		{method: "GET", path: "/" + hash, scope: ScopeObjectsRead},
+		{method: "POST", path: "/api/objects/missing", body: `{"Hashes":["` + hash + `"]}`, scope: ScopeObjectsRead},
+		{method: "POST", path: "/api/get", body: `{"Root":"` + root + `"}`, scope: ScopeObjectsRead},
+		{method: "POST", path: "/api/getmany", body: `{"Roots":["` + root + `"]}`, scope: ScopeObjectsRead},
+		{method: "GET", path: "/api/car/" + root, scope: ScopeObjectsRead},
+		{method: "GET", path: "/api/tags", scope: ScopeObjectsRead},
+		{method: "GET", path: "/api/tags/team%2Fapp", scope: ScopeObjectsRead},
+		{method: "GET", path: "/api/tags/team%2Fapp/history", scope: ScopeObjectsRead},
+		{method: "GET", path: "/api/log/sth", scope: ScopeObjectsRead},
+		{method: "GET", path: "/api/log/inclusion?leaf=" + hash, scope: ScopeObjectsRead},
+		{method: "GET", path: "/api/log/consistency?first=1&second=1", scope: ScopeObjectsRead},
+		{method: "GET", path: "/blobs/" + root, scope: ScopeObjectsRead},
+
+		{method: "POST", path: "/api/objects", body: "object", scope: ScopeObjectsWrite},
+		{method: "POST", path: "/", body: "object", scope: ScopeObjectsWrite},
+		{method: "POST", path: "/api/objects/batch", body: "\x06object", scope: ScopeObjectsWrite},
+		{method: "POST", path: "/api/update", body: `{}`, scope: ScopeObjectsWrite},
+		{method: "POST", path: "/api/rename", body: `{}`, scope: ScopeObjectsWrite},
+		{method: "POST", path: "/api/remove", body: `{}`, scope: ScopeObjectsWrite},
+		{method: "POST", path: "/api/car", body: "", scope: ScopeObjectsWrite},
+
+		{method: "POST", path: "/api/tags/team%2Fapp", body: root, scope: "team/app", tag: true},
+		{method: "DELETE", path: "/api/tags/team%2Fapp", scope: "team/app", tag: true},
+		// Outside of the team/ namespace, despite sharing its prefix.
+		{method: "POST", path: "/api/tags/teammate", body: root, scope: "teammate", tag: true},
+		{method: "POST", path: "/api/tags/other", body: root, scope: "other", tag: true},
+		{method: "DELETE", path: "/api/tags/other", scope: "other", tag: true},
+
+		{method: "POST", path: "/api/admin/gc", body: `{"DryRun":true}`, scope: ScopeAdmin},
+	}
+}
+
+// allowed returns whether a request with the given scopes may access the route.
+func (r authTestRoute) allowed(scopes []string) bool {
+	for _, scope := range scopes {
+		if r.tag {
+			if strings.HasPrefix(scope, ScopeTagsWritePrefix) && strings.HasPrefix(r.scope, strings.TrimPrefix(scope, ScopeTagsWritePrefix)) {
+				return true
+			}
+		} else if scope == r.scope {
+			return true
+		}
+	}
+	return false
+}
+
+func doAuthTestRequest(t *testing.T, url string, route authTestRoute, token string) int {
+	req, err := http.NewRequest(route.method, url+route.path, strings.NewReader(route.body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestAuthScopes(t *testing.T) {
+	url, root := newTestServer(t, testTokens)
+	tokens := []struct {
+		token  string
+		scopes []string
+	}{
+		{token: "", scopes: nil},
+		{token: "reader-token", scopes: []string{ScopeObjectsRead}},
+		{token: "writer-token", scopes: []string{ScopeObjectsWrite}},
+		{token: "team-token", scopes: []string{ScopeTagsWritePrefix + "team/"}},
+		{token: "admin-token", scopes: []string{ScopeAdmin}},
+	}
+	for _, route := range authTestRoutes(root) {
+		for _, token := range tokens {
+			status := doAuthTestRequest(t, url, route, token.token)
+			denied := status == http.StatusUnauthorized || status == http.StatusForbidden
+			if route.allowed(token.scopes) {
+				if denied {
+					t.Errorf("%s %s with scopes %v: got status %d, want allowed", route.method, route.path, token.scopes, status)
+				}
+				continue
+			}
+			// Requests without a token are asked for one, while tokens lacking a scope are refused.
+			want := http.StatusForbidden
+			if token.token == "" {
+				want = http.StatusUnauthorized
+			}
+			if status != want {
+				t.Errorf("%s %s with scopes %v: got status %d, want %d", route.method, route.path, token.scopes, status, want)
+			}
+		}
+	}
+}
+
+func TestAuthAnonymousScopes(t *testing.T) {
+	url, root := newTestServer(t, strings.Replace(testTokens, "anonymous_scopes = []", `anonymous_scopes = ["objects:read"]`, 1))
+	for _, route := range authTestRoutes(root) {
+		status := doAuthTestRequest(t, url, route, "")
+		denied := status == http.StatusUnauthorized || status == http.StatusForbidden
+		if route.allowed([]string{ScopeObjectsRead}) {
+			if denied {
+				t.Errorf("anonymous %s %s: got status %d, want allowed", route.method, route.path, status)
+			}
+		} else if status != http.StatusUnauthorized {
+			t.Errorf("anonymous %s %s: got status %d, want %d", route.method, route.path, status, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestAuthInvalidToken(t *testing.T) {
+	url, root := newTestServer(t, testTokens)
+	for _, route := range authTestRoutes(root) {
+		status := doAuthTestRequest(t, url, route, "invalid-token")
+		if status != http.StatusUnauthorized {
+			t.Errorf("%s %s with invalid token: got status %d, want %d", route.method, route.path, status, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestParseTokensInvalidScope(t *testing.T) {
+	for _, scope := range []string{"root", "tags:write:team", "tags:write"} {
+		dir := t.TempDir()
+		tokensFile := filepath.Join(dir, "tokens.toml")
+		err := ioutil.WriteFile(tokensFile, []byte(strings.Replace(testTokens, `"admin"]`, `"`+scope+`"]`, 1)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ParseTokens(tokensFile)
+		if err == nil {
+			t.Errorf("ParseTokens accepted the invalid scope %q", scope)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/ent/car"
+	"github.com/ipfs/go-cid"
+)
+
+type CarImportResponse struct {
+	Roots []string
+	// Blocks is the number of blocks in the file, and Added the number of those that were not
+	// already present.
+	Blocks int
+	Added  int
+}
+
+// carExportHandler streams a CAR file containing the DAG under the given root.
+func (s *Server) carExportHandler(c *gin.Context) {
+	root, err := cid.Decode(c.Param("root"))
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	has, err := s.blobStore.Has(c, root)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if !has {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Header("Content-Type", "application/vnd.ipld.car; version=1")
+	c.Status(http.StatusOK)
+	// Errors after this point can only be detected by clients as a truncated file.
+	count, err := car.Export(c, s.blobStore, root, c.Writer)
+	if err != nil {
+		log.Printf("could not export %s: %v", root, err)
+		return
+	}
+	log.Printf("exported %d blocks", count)
+}
+
+// DefaultMaxCarBytes is the maximum size of a CAR file imported in a single request, unless
+// overridden by Server.MaxCarBytes.
+const DefaultMaxCarBytes = 4 << 30
+
+// carImportHandler adds all the blocks of the CAR file in the request body.
+func (s *Server) carImportHandler(c *gin.Context) {
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, s.maxCarBytes())
+	res, err := car.Import(c, s.blobStore, body)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	log.Printf("imported %d blocks (%d new)", res.Blocks, res.Added)
+	roots := []string{}
+	for _, root := range res.Roots {
+		roots = append(roots, root.String())
+	}
+	c.JSON(http.StatusOK, CarImportResponse{
+		Roots:  roots,
+		Blocks: res.Blocks,
+		Added:  res.Added,
+	})
+}
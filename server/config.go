@@ -0,0 +1,279 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/BurntSushi/toml"
+	"github.com/google/ent/datastore"
+	"github.com/google/ent/nodeservice"
+	"github.com/google/ent/objectstore"
+	"github.com/google/ent/tagstore"
+	"github.com/google/ent/translog"
+	"github.com/google/ent/utils"
+)
+
+// Config is the configuration of a server, usually parsed from a TOML file, e.g.:
+//
+//	listen_address = ":8080"
+//	domain_name = "localhost:8080"
+//	templates_dir = "templates"
+//	hash = "blake3"
+//
+//	[objects]
+//	backend = "cloud"
+//	bucket = "ent-objects"
+//
+//	[tags]
+//	backend = "file"
+//	dir = "data/tags"
+type Config struct {
+	ListenAddress string `toml:"listen_address"`
+	DomainName    string `toml:"domain_name"`
+	TemplatesDir  string `toml:"templates_dir"`
+	// TokensFile is the path of the file listing the tokens accepted by the server, in the format
+	// of TokensFile; if empty, authentication is disabled and all requests are allowed.
+	TokensFile string `toml:"tokens_file"`
+	// LogKey is the path of the file containing the private key used to sign the tree heads of the
+	// transparency log of tag updates, as created by `ent keygen`; if empty, the log is disabled.
+	LogKey string `toml:"log_key"`
+	// Hash is the hash function of objects uploaded without specifying one, and of nodes created by
+	// the server: "sha2-256" (the default), "sha2-512" or "blake3".
+	Hash string `toml:"hash"`
+
+	Objects BackendConfig `toml:"objects"`
+	Tags    BackendConfig `toml:"tags"`
+
+	Limits LimitsConfig `toml:"limits"`
+}
+
+// BackendConfig selects where objects or tags are stored.
+type BackendConfig struct {
+	// Backend is either "file" or "cloud".
+	Backend string `toml:"backend"`
+	// Dir is the directory used by the "file" backend.
+	Dir string `toml:"dir"`
+	// Bucket is the Google Cloud Storage bucket used by the "cloud" backend.
+	Bucket string `toml:"bucket"`
+}
+
+// LimitsConfig contains limits on the requests handled by the server; zero values mean the
+// defaults are used.
+type LimitsConfig struct {
+	MaxBatchSize   int   `toml:"max_batch_size"`
+	MaxBatchBytes  int64 `toml:"max_batch_bytes"`
+	MaxCarBytes    int64 `toml:"max_car_bytes"`
+	MaxHeaderBytes int   `toml:"max_header_bytes"`
+	// ReadHeaderTimeout bounds the time spent reading the headers of a request. ReadTimeout and
+	// WriteTimeout bound the time spent reading a whole request, including its body, and writing a
+	// whole response, including streamed ones. Zero values mean no limit.
+	ReadHeaderTimeout Duration `toml:"read_header_timeout"`
+	ReadTimeout       Duration `toml:"read_timeout"`
+	WriteTimeout      Duration `toml:"write_timeout"`
+}
+
+// Duration is a time.Duration that is parsed from a string such as "10s" in config files.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// ParseConfig parses and validates the config file with the given name. Unknown keys are treated
+// as errors, so that typos do not silently result in a default value being used.
+func ParseConfig(filename string) (Config, error) {
+	var config Config
+
+	f, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return config, err
+	}
+	md, err := toml.Decode(string(f), &config)
+	if err != nil {
+		return config, fmt.Errorf("could not parse %s: %v", filename, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := []string{}
+		for _, k := range undecoded {
+			keys = append(keys, k.String())
+		}
+		return config, fmt.Errorf("unknown keys in %s: %s", filename, strings.Join(keys, ", "))
+	}
+	err = config.validate()
+	if err != nil {
+		return config, fmt.Errorf("invalid config %s: %v", filename, err)
+	}
+	return config, nil
+}
+
+func (c Config) validate() error {
+	if c.ListenAddress == "" {
+		return fmt.Errorf("listen_address must be set")
+	}
+	if c.Hash != "" {
+		_, err := utils.ParseHashType(c.Hash)
+		if err != nil {
+			return err
+		}
+	}
+	err := c.Objects.validate()
+	if err != nil {
+		return fmt.Errorf("objects: %v", err)
+	}
+	err = c.Tags.validate()
+	if err != nil {
+		return fmt.Errorf("tags: %v", err)
+	}
+	if c.Limits.MaxBatchSize < 0 || c.Limits.MaxBatchBytes < 0 || c.Limits.MaxCarBytes < 0 || c.Limits.MaxHeaderBytes < 0 || c.Limits.ReadHeaderTimeout.Duration < 0 || c.Limits.ReadTimeout.Duration < 0 || c.Limits.WriteTimeout.Duration < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	return nil
+}
+
+func (c BackendConfig) validate() error {
+	switch c.Backend {
+	case "file":
+		if c.Dir == "" {
+			return fmt.Errorf("dir must be set for the file backend")
+		}
+		if c.Bucket != "" {
+			return fmt.Errorf("bucket is not supported by the file backend")
+		}
+	case "cloud":
+		if c.Bucket == "" {
+			return fmt.Errorf("bucket must be set for the cloud backend")
+		}
+		if c.Dir != "" {
+			return fmt.Errorf("dir is not supported by the cloud backend")
+		}
+	case "":
+		return fmt.Errorf("backend must be set")
+	default:
+		return fmt.Errorf("unknown backend %q", c.Backend)
+	}
+	return nil
+}
+
+// NewServer creates the stores described by the config and returns a server using them. The
+// Google Cloud Storage client is only created if one of the backends needs it, and failing to
+// create it is an error.
+func (c Config) NewServer(ctx context.Context) (*Server, error) {
+	var storageClient *storage.Client
+	if c.Objects.Backend == "cloud" || c.Tags.Backend == "cloud" {
+		var err error
+		storageClient, err = storage.NewClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not create storage client: %v", err)
+		}
+	}
+
+	if c.Hash != "" {
+		// The default hash function applies to the whole process, including the nodes created
+		// while handling requests.
+		hashType, err := utils.ParseHashType(c.Hash)
+		if err != nil {
+			return nil, err
+		}
+		utils.DefaultHashType = hashType
+		log.Printf("default hash function: %s", c.Hash)
+	}
+
+	s := &Server{
+		DomainName:    c.DomainName,
+		TemplatesDir:  c.TemplatesDir,
+		MaxBatchSize:  c.Limits.MaxBatchSize,
+		MaxBatchBytes: c.Limits.MaxBatchBytes,
+		MaxCarBytes:   c.Limits.MaxCarBytes,
+	}
+
+	if c.TokensFile != "" {
+		auth, err := ParseTokens(c.TokensFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load tokens: %v", err)
+		}
+		s.Auth = auth
+	} else {
+		log.Print("no tokens file configured, authentication is disabled")
+	}
+
+	for _, b := range []BackendConfig{c.Objects, c.Tags} {
+		if b.Backend == "file" {
+			err := os.MkdirAll(b.Dir, 0755)
+			if err != nil {
+				return nil, fmt.Errorf("could not create dir: %v", err)
+			}
+		}
+	}
+
+	switch c.Objects.Backend {
+	case "file":
+		s.ObjectStore = objectstore.Store{
+			Inner: datastore.File{
+				DirName: c.Objects.Dir,
+			},
+		}
+	case "cloud":
+		s.ObjectStore = objectstore.Store{
+			Inner: datastore.Cloud{
+				Client:     storageClient,
+				BucketName: c.Objects.Bucket,
+			},
+		}
+	}
+
+	switch c.Tags.Backend {
+	case "file":
+		s.TagStore = tagstore.File{
+			DirName: c.Tags.Dir,
+		}
+	case "cloud":
+		s.TagStore = tagstore.Cloud{
+			Client:     storageClient,
+			BucketName: c.Tags.Bucket,
+		}
+	}
+
+	if c.LogKey != "" {
+		key, err := utils.ReadPrivateKey(c.LogKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load log key: %v", err)
+		}
+		s.Log = &translog.Log{
+			Nodes: nodeservice.DataStore{
+				Inner: s.ObjectStore,
+			},
+			Tags: s.TagStore,
+			Key:  key,
+		}
+		log.Printf("log public key: %s", base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)))
+	}
+
+	return s, nil
+}
@@ -0,0 +1,83 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/ent/translog"
+)
+
+func (s *Server) logTreeHeadHandler(c *gin.Context) {
+	h, err := s.Log.TreeHead(c)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, h)
+}
+
+func (s *Server) logInclusionHandler(c *gin.Context) {
+	leafHash, err := hex.DecodeString(c.Query("leaf_hash"))
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	treeSize, err := strconv.ParseUint(c.Query("tree_size"), 10, 64)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	p, err := s.Log.InclusionProof(c, leafHash, treeSize)
+	if err == translog.ErrNotFound {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+func (s *Server) logConsistencyHandler(c *gin.Context) {
+	first, err := strconv.ParseUint(c.Query("first"), 10, 64)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	second, err := strconv.ParseUint(c.Query("second"), 10, 64)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	p, err := s.Log.ConsistencyProof(c, first, second)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
@@ -0,0 +1,204 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package server implements the HTTP API and web UI on top of an object store and a tag store.
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/ent/nodeservice"
+	"github.com/google/ent/objectstore"
+	"github.com/google/ent/tagstore"
+	"github.com/google/ent/translog"
+	"github.com/ipfs/go-cid"
+)
+
+const wwwSegment = "www"
+const tagsSegment = "tags"
+
+// Server serves the Ent API, and optionally the web UI, from an object store and a tag store.
+type Server struct {
+	ObjectStore objectstore.Store
+	TagStore    tagstore.TagStore
+	// DomainName is the domain under which nodes and tags are served as websites, e.g.
+	// <cid>.www.<DomainName>; if empty, all requests are handled by the API and browse UI.
+	DomainName string
+	// TemplatesDir is the directory containing the HTML templates and static files of the browse
+	// UI; if empty, the UI is disabled.
+	TemplatesDir string
+	// MaxBatchSize and MaxBatchBytes limit the size of requests to batch endpoints; if zero,
+	// DefaultMaxBatchSize and DefaultMaxBatchBytes are used.
+	MaxBatchSize  int
+	MaxBatchBytes int64
+	// MaxCarBytes limits the size of CAR files imported via /api/car; if zero, DefaultMaxCarBytes
+	// is used.
+	MaxCarBytes int64
+	// Log, if not nil, records every tag update in a transparency log.
+	Log *translog.Log
+	// Auth authenticates requests and authorizes them based on their scopes; if nil, all requests
+	// are allowed.
+	Auth *Auth
+
+	blobStore     nodeservice.NodeService
+	handlerBrowse http.Handler
+	handlerWWW    http.Handler
+}
+
+// Handler returns the root HTTP handler, dispatching requests based on their host.
+func (s *Server) Handler() http.Handler {
+	s.blobStore = nodeservice.DataStore{
+		Inner: s.ObjectStore,
+	}
+
+	{
+		router := gin.Default()
+		router.RedirectTrailingSlash = false
+		router.RedirectFixedPath = false
+		// Route on the escaped path, so that tag names containing slashes can be passed as a single
+		// escaped segment, e.g. /api/tags/team%2Fapp.
+		router.UseRawPath = true
+		if s.TemplatesDir != "" {
+			router.LoadHTMLGlob(filepath.Join(s.TemplatesDir, "*"))
+		}
+		router.Use(s.authenticate)
+		read := s.requireScope(ScopeObjectsRead)
+		write := s.requireScope(ScopeObjectsWrite)
+
+		// Uninterpreted bytes by hash, no DAG traversal.
+		router.GET("/api/objects/:objecthash", read, s.apiObjectsGetHandler)
+		router.POST("/api/objects", write, s.apiObjectsUpdateHandler)
+		router.POST("/api/objects/missing", read, s.apiObjectsMissingHandler)
+		router.POST("/api/objects/batch", write, s.apiObjectsBatchUpdateHandler)
+
+		// Objects are also served at the root, which is where nodeservice.Remote expects them.
+		router.POST("/", write, s.apiObjectsUpdateHandler)
+		router.NoRoute(read, s.rootObjectsGetHandler)
+
+		router.POST("/api/get", read, s.apiGetHandler)
+		router.POST("/api/getmany", read, s.apiGetManyHandler)
+		router.POST("/api/update", write, s.apiUpdateHandler)
+		router.POST("/api/rename", write, s.apiRenameHandler)
+		router.POST("/api/remove", write, s.apiRemoveHandler)
+
+		router.GET("/api/car/:root", read, s.carExportHandler)
+		router.POST("/api/car", write, s.carImportHandler)
+
+		router.GET("/api/tags", read, s.listTagsHandler)
+		router.GET("/api/tags/:name", read, s.getTagHandler)
+		router.POST("/api/tags/:name", s.postTagHandler)
+		router.DELETE("/api/tags/:name", s.deleteTagHandler)
+		router.GET("/api/tags/:name/history", read, s.getTagHistoryHandler)
+
+		if s.Log != nil {
+			router.GET("/api/log/sth", read, s.logTreeHeadHandler)
+			router.GET("/api/log/inclusion", read, s.logInclusionHandler)
+			router.GET("/api/log/consistency", read, s.logConsistencyHandler)
+		}
+
+		// Without authentication, every request would be granted the admin scope.
+		if s.Auth != nil {
+			router.POST("/api/admin/gc", s.requireScope(ScopeAdmin), s.apiAdminGCHandler)
+		}
+
+		router.GET("/blobs/:root", read, s.browseBlobHandler)
+		router.GET("/blobs/:root/*path", read, s.browseBlobHandler)
+
+		if s.TemplatesDir != "" {
+			router.StaticFile("/static/tailwind.min.css", filepath.Join(s.TemplatesDir, "tailwind.min.css"))
+		}
+
+		s.handlerBrowse = router
+	}
+	{
+		router := gin.Default()
+		router.Use(s.authenticate)
+		router.GET("/*path", s.requireScope(ScopeObjectsRead), s.renderHandler)
+		s.handlerWWW = router
+	}
+
+	return http.HandlerFunc(s.handlerRoot)
+}
+
+func (s *Server) maxBatchSize() int {
+	if s.MaxBatchSize == 0 {
+		return DefaultMaxBatchSize
+	}
+	return s.MaxBatchSize
+}
+
+func (s *Server) maxCarBytes() int64 {
+	if s.MaxCarBytes == 0 {
+		return DefaultMaxCarBytes
+	}
+	return s.MaxCarBytes
+}
+
+func (s *Server) maxBatchBytes() int64 {
+	if s.MaxBatchBytes == 0 {
+		return DefaultMaxBatchBytes
+	}
+	return s.MaxBatchBytes
+}
+
+func (s *Server) hostSegments(host string) []string {
+	host = strings.TrimSuffix(host, s.DomainName)
+	host = strings.TrimSuffix(host, ".")
+	hostSegments := strings.Split(host, ".")
+	if len(hostSegments) > 0 && hostSegments[0] == "" {
+		return hostSegments[1:]
+	} else {
+		return hostSegments
+	}
+}
+
+func (s *Server) redirectToCid(c *gin.Context, target cid.Cid, path string) {
+	c.Redirect(http.StatusFound, fmt.Sprintf("//%s.%s.%s%s", target.String(), wwwSegment, s.DomainName, path))
+}
+
+func (s *Server) handlerRoot(w http.ResponseWriter, r *http.Request) {
+	hostSegments := s.hostSegments(r.Host)
+	log.Printf("host segments: %#v", hostSegments)
+	if s.DomainName == "" || len(hostSegments) == 0 {
+		s.handlerBrowse.ServeHTTP(w, r)
+	} else {
+		s.handlerWWW.ServeHTTP(w, r)
+	}
+}
+
+func indexHandler(c *gin.Context) {
+	c.HTML(http.StatusOK, "index.tmpl", gin.H{})
+}
+
+func parsePath(p string) []string {
+	if p == "/" || p == "" {
+		return []string{}
+	} else {
+		return strings.Split(strings.TrimPrefix(p, "/"), "/")
+	}
+}
+
+func parseHost(p string) []string {
+	if p == "/" || p == "" {
+		return []string{}
+	} else {
+		return strings.Split(strings.TrimPrefix(p, "/"), "/")
+	}
+}
@@ -0,0 +1,359 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"encoding/base32"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/ent/nodeservice"
+	"github.com/google/ent/tagstore"
+	"github.com/google/ent/utils"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+)
+
+func (s *Server) serveUI(c *gin.Context, root cid.Cid, segments []string, target cid.Cid, node format.Node) {
+	if s.TemplatesDir == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	templateSegments := []TemplateSegment{}
+	for i, segment := range segments {
+		templateSegments = append(templateSegments, TemplateSegment{
+			Name: segment,
+			Path: path.Join(segments[0 : i+1]...),
+		})
+	}
+	pathStr := c.Param("path")
+	switch node := node.(type) {
+	case *merkledag.ProtoNode:
+		if utils.IsChunkedFile(node) {
+			// Too large to be displayed and edited inline.
+			c.HTML(http.StatusOK, "browse.tmpl", gin.H{
+				"type":         "file",
+				"chunked":      true,
+				"size":         utils.FileSize(node),
+				"wwwHost":      wwwSegment + "." + s.DomainName,
+				"root":         root,
+				"path":         pathStr,
+				"parentPath":   path.Dir(path.Dir(pathStr)),
+				"pathSegments": templateSegments,
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "browse.tmpl", gin.H{
+			"type":         "directory",
+			"wwwHost":      wwwSegment + "." + s.DomainName,
+			"root":         root,
+			"path":         pathStr,
+			"parentPath":   path.Dir(path.Dir(pathStr)),
+			"pathSegments": templateSegments,
+			"node":         node,
+		})
+	case *merkledag.RawNode:
+		c.HTML(http.StatusOK, "browse.tmpl", gin.H{
+			"type":         "file",
+			"wwwHost":      wwwSegment + "." + s.DomainName,
+			"root":         root,
+			"path":         pathStr,
+			"parentPath":   path.Dir(path.Dir(pathStr)),
+			"pathSegments": templateSegments,
+			"blob":         node.RawData(),
+			"blob_str":     string(node.RawData()),
+		})
+	}
+}
+
+type TemplateSegment struct {
+	Name string
+	Path string
+}
+
+func (s *Server) serveWWW(c *gin.Context, root cid.Cid, segments []string) {
+	target, err := s.traverse(c, root, segments)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	log.Printf("target: %s", target)
+	log.Printf("target CID: %#v", target.Prefix())
+
+	node, err := s.blobStore.Get(c, target)
+	if err != nil {
+		log.Print(err)
+		c.Abort()
+		return
+	}
+	switch node := node.(type) {
+	case *merkledag.RawNode:
+		c.Header("ent-hash", target.String())
+		ext := filepath.Ext(segments[len(segments)-1])
+		contentType := mime.TypeByExtension(ext)
+		if contentType == "" {
+			contentType = http.DetectContentType(node.RawData())
+		}
+		c.Header("Content-Type", contentType)
+		c.Data(http.StatusOK, "", node.RawData())
+		return
+	case *merkledag.ProtoNode:
+		if utils.IsChunkedFile(node) {
+			s.serveChunkedFile(c, target, segments, node)
+			return
+		}
+		s.serveUI(c, root, segments, target, node)
+	default:
+		log.Printf("unknown codec: %v", target.Prefix().Codec)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+}
+
+// serveChunkedFile streams the chunks of a large file one at a time.
+func (s *Server) serveChunkedFile(c *gin.Context, target cid.Cid, segments []string, node *merkledag.ProtoNode) {
+	r, err := nodeservice.NewFileReader(c, s.blobStore, target)
+	if err != nil {
+		log.Print(err)
+		c.Abort()
+		return
+	}
+	defer r.Close()
+	br := bufio.NewReader(r)
+	// If the root itself is the file, there is no name to take the extension from.
+	contentType := ""
+	if len(segments) > 0 {
+		contentType = mime.TypeByExtension(filepath.Ext(segments[len(segments)-1]))
+	}
+	if contentType == "" {
+		head, _ := br.Peek(512)
+		contentType = http.DetectContentType(head)
+	}
+	c.DataFromReader(http.StatusOK, int64(utils.FileSize(node)), contentType, br, map[string]string{
+		"ent-hash": target.String(),
+	})
+}
+
+func (s *Server) browseBlobHandler(c *gin.Context) {
+	pathString := c.Param("path")
+	log.Printf("path: %q", pathString)
+	segments := parsePath(pathString)
+	log.Printf("segments: %#v", segments)
+
+	if strings.HasSuffix(c.Request.URL.Path, "/") {
+		to := strings.TrimSuffix(c.Request.URL.Path, "/")
+		log.Printf("redirecting to: %q", to)
+		c.Redirect(http.StatusMovedPermanently, to)
+		return
+	}
+
+	root, err := cid.Decode(c.Param("root"))
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	target, err := s.traverse(c, root, segments)
+	if err != nil {
+		log.Print(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	node, err := s.blobStore.Get(c, target)
+	if err != nil {
+		log.Print(err)
+		c.Abort()
+		return
+	}
+	if archiveFormat := c.Query("format"); archiveFormat != "" {
+		name := root.String()
+		if len(segments) > 0 {
+			name = segments[len(segments)-1]
+		}
+		s.serveArchive(c, target, node, name, archiveFormat)
+		return
+	}
+	s.serveUI(c, root, segments, target, node)
+}
+
+func (s *Server) renderHandler(c *gin.Context) {
+	hostSegments := s.hostSegments(c.Request.Host)
+	pathString := c.Param("path")
+	log.Printf("path: %v", pathString)
+	segments := parsePath(pathString)
+	log.Printf("segments: %#v", segments)
+	if pathString != "/" && strings.HasSuffix(pathString, "/") {
+		c.Redirect(http.StatusMovedPermanently, strings.TrimSuffix(pathString, "/"))
+		return
+	}
+
+	root := cid.Undef
+	var err error
+
+	if len(hostSegments) < 2 {
+		log.Printf("invalid host segments: %#v", hostSegments)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	switch hostSegments[len(hostSegments)-1] {
+	case wwwSegment:
+		if len(hostSegments) != 2 {
+			log.Printf("invalid host segments: %#v", hostSegments)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		baseDomain := hostSegments[0]
+		log.Printf("base domain: %s", baseDomain)
+		if baseDomain == "empty" {
+			newNode := utils.NewProtoNode()
+			err := s.blobStore.Add(c, newNode)
+			if err != nil {
+				log.Print(err)
+				c.AbortWithStatus(http.StatusNotFound)
+				return
+			}
+			target := newNode.Cid()
+			log.Printf("target: %s", target.String())
+			s.redirectToCid(c, target, "")
+			return
+		}
+
+		root, err = cid.Decode(baseDomain)
+		if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		log.Printf("root: %v", root)
+	case tagsSegment:
+		tagName, err := hostTagName(hostSegments[:len(hostSegments)-1])
+		if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		if err := tagstore.ValidateName(tagName); err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		tagValueBytes, err := s.TagStore.Get(c, tagName)
+		if err == tagstore.ErrNotFound {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		tagValue, err := tagstore.ParseRoot(c, s.blobStore, tagValueBytes)
+		if err != nil {
+			log.Print(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		s.serveWWW(c, tagValue, segments)
+		return
+	default:
+		log.Printf("invalid segment")
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	s.serveWWW(c, root, segments)
+}
+
+// hostTagName returns the name of the tag served under the given host segments, which precede
+// tagsSegment. The segments of nested tag names are in reverse order, most specific first, as for
+// domain names, so that e.g. team/app/release is served under release.app.team.tags.<domain>. Each
+// host segment is decoded with tagSegment.
+func hostTagName(hostSegments []string) (string, error) {
+	segments := make([]string, len(hostSegments))
+	for i, label := range hostSegments {
+		segment, err := tagSegment(label)
+		if err != nil {
+			return "", err
+		}
+		segments[len(hostSegments)-1-i] = segment
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// encodedLabelPrefix marks the DNS labels that encode a tag segment, see tagHostLabel.
+const encodedLabelPrefix = "x--"
+
+// maxLabelLength is the maximum length of a DNS label.
+const maxLabelLength = 63
+
+// labelEncoding encodes tag segments into DNS labels, which are case-insensitive.
+var labelEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// tagHostLabel returns the DNS label under which the tag segment is served. Segments that are
+// already lowercase DNS labels, i.e. made of lowercase letters, digits and hyphens, and neither
+// starting nor ending with a hyphen, are used as they are, unless they start with
+// encodedLabelPrefix; other segments are encoded as encodedLabelPrefix followed by their lowercase
+// unpadded base32 encoding. Segments whose label would exceed maxLabelLength cannot be served.
+func tagHostLabel(segment string) (string, error) {
+	if isPlainLabel(segment) {
+		return segment, nil
+	}
+	label := encodedLabelPrefix + strings.ToLower(labelEncoding.EncodeToString([]byte(segment)))
+	if len(label) > maxLabelLength {
+		return "", fmt.Errorf("tag segment %q is too long to be served as a DNS label", segment)
+	}
+	return label, nil
+}
+
+// tagSegment returns the tag segment served under the DNS label, as encoded by tagHostLabel.
+func tagSegment(label string) (string, error) {
+	label = strings.ToLower(label)
+	if strings.HasPrefix(label, encodedLabelPrefix) {
+		b, err := labelEncoding.DecodeString(strings.ToUpper(strings.TrimPrefix(label, encodedLabelPrefix)))
+		if err != nil {
+			return "", fmt.Errorf("invalid encoded label %q: %v", label, err)
+		}
+		return string(b), nil
+	}
+	if !isPlainLabel(label) {
+		return "", fmt.Errorf("invalid label %q", label)
+	}
+	return label, nil
+}
+
+// isPlainLabel returns whether s is a lowercase DNS label that tagHostLabel uses as it is.
+func isPlainLabel(s string) bool {
+	if s == "" || len(s) > maxLabelLength || strings.HasPrefix(s, encodedLabelPrefix) {
+		return false
+	}
+	if s[0] == '-' || s[len(s)-1] == '-' {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import "testing"
+
+func TestTagHostLabel(t *testing.T) {
+	for _, tc := range []struct {
+		segment string
+		label   string
+	}{
+		{"release", "release"},
+		{"app-2", "app-2"},
+		{"v1.2", "x--oyys4mq"},
+		{"Team", "x--krswc3i"},
+		{"-app", "x--fvqxa4a"},
+		{"x--abc", "x--paws2ylcmm"},
+	} {
+		label, err := tagHostLabel(tc.segment)
+		if err != nil {
+			t.Fatalf("tagHostLabel(%q): %v", tc.segment, err)
+		}
+		if label != tc.label {
+			t.Errorf("tagHostLabel(%q) = %q, want %q", tc.segment, label, tc.label)
+		}
+		segment, err := tagSegment(label)
+		if err != nil {
+			t.Fatalf("tagSegment(%q): %v", label, err)
+		}
+		if segment != tc.segment {
+			t.Errorf("tagSegment(%q) = %q, want %q", label, segment, tc.segment)
+		}
+	}
+}
+
+func TestTagHostLabelTooLong(t *testing.T) {
+	_, err := tagHostLabel("a_very_long_tag_segment_that_does_not_fit")
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestHostTagName(t *testing.T) {
+	name, err := hostTagName([]string{"RELEASE", "x--oyys4mq", "team"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "team/v1.2/release" {
+		t.Errorf("hostTagName = %q", name)
+	}
+	for _, label := range []string{"a_b", "-a", "x--!!"} {
+		_, err := hostTagName([]string{label})
+		if err == nil {
+			t.Errorf("hostTagName(%q): expected an error", label)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package tagstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// Cloud is an implementation of TagStore using a Google Cloud Storage bucket, with one object per
+// tag, and one object per history entry.
+type Cloud struct {
+	Client     *storage.Client
+	BucketName string
+}
+
+// Set retries until the tag is updated without any concurrent modification, so that the previous
+// value recorded in the history is accurate.
+func (s Cloud) Set(ctx context.Context, name string, value []byte) error {
+	err := checkName(name)
+	if err != nil {
+		return err
+	}
+	for {
+		current, conditions, err := s.read(ctx, name)
+		if err != nil {
+			return err
+		}
+		err = s.write(ctx, s.Client.Bucket(s.BucketName).Object(name).If(conditions), value)
+		if isPreconditionFailed(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		return s.appendHistory(ctx, name, current, value)
+	}
+}
+
+func (s Cloud) Get(ctx context.Context, name string) ([]byte, error) {
+	err := checkName(name)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := s.Client.Bucket(s.BucketName).Object(name).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// CompareAndSet uses generation preconditions, so that the update fails if the object was modified
+// since its value was compared.
+func (s Cloud) CompareAndSet(ctx context.Context, name string, expected []byte, value []byte) error {
+	err := checkName(name)
+	if err != nil {
+		return err
+	}
+	current, conditions, err := s.read(ctx, name)
+	if err != nil {
+		return err
+	}
+	conflict := &ConflictError{
+		Name:     name,
+		Expected: expected,
+		Actual:   current,
+	}
+	if (expected == nil) != (current == nil) || !bytes.Equal(expected, current) {
+		return conflict
+	}
+
+	err = s.write(ctx, s.Client.Bucket(s.BucketName).Object(name).If(conditions), value)
+	if isPreconditionFailed(err) {
+		// Best effort, only used for reporting.
+		conflict.Actual, _ = s.Get(ctx, name)
+		return conflict
+	} else if err != nil {
+		return err
+	}
+	return s.appendHistory(ctx, name, current, value)
+}
+
+// Delete retries until the tag is deleted without any concurrent modification, so that the value
+// recorded in the history is accurate.
+func (s Cloud) Delete(ctx context.Context, name string) error {
+	err := checkName(name)
+	if err != nil {
+		return err
+	}
+	for {
+		current, conditions, err := s.read(ctx, name)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return ErrNotFound
+		}
+		err = s.Client.Bucket(s.BucketName).Object(name).If(conditions).Delete(ctx)
+		if isPreconditionFailed(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		return s.appendHistory(ctx, name, current, nil)
+	}
+}
+
+func (s Cloud) List(ctx context.Context, prefix string, after string, limit int) ([]string, error) {
+	it := s.Client.Bucket(s.BucketName).Objects(ctx, &storage.Query{
+		Prefix:      prefix,
+		StartOffset: after,
+	})
+	names := []string{}
+	for limit <= 0 || len(names) < limit {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		// StartOffset is inclusive. Skip the history directory and reserved tags, such as the head of
+		// the transparency log.
+		if attrs.Name == after || IsReserved(attrs.Name) {
+			continue
+		}
+		names = append(names, attrs.Name)
+	}
+	return names, nil
+}
+
+func (s Cloud) History(ctx context.Context, name string) ([]HistoryEntry, error) {
+	bucket := s.Client.Bucket(s.BucketName)
+	err := checkName(name)
+	if err != nil {
+		return nil, err
+	}
+	// The delimiter excludes the history of the tags in the namespace with the same name.
+	it := bucket.Objects(ctx, &storage.Query{
+		Prefix:    s.historyPrefix(name),
+		Delimiter: "/",
+	})
+	entries := []HistoryEntry{}
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if attrs.Name == "" {
+			continue
+		}
+		rc, err := bucket.Object(attrs.Name).NewReader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var entry HistoryEntry
+		err = json.NewDecoder(rc).Decode(&entry)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (s Cloud) historyPrefix(name string) string {
+	return historyDirName + "/" + name + "/"
+}
+
+// appendHistory stores the entry in its own object, named after the zero-padded timestamp so that
+// listing returns entries in chronological order.
+func (s Cloud) appendHistory(ctx context.Context, name string, previous []byte, value []byte) error {
+	now := time.Now().UTC()
+	b, err := json.Marshal(HistoryEntry{
+		Time:     now,
+		Previous: previous,
+		Value:    value,
+	})
+	if err != nil {
+		return err
+	}
+	obj := s.Client.Bucket(s.BucketName).Object(fmt.Sprintf("%s%020d", s.historyPrefix(name), now.UnixNano()))
+	return s.write(ctx, obj, b)
+}
+
+// read returns the current value of the tag, or nil if it does not exist, together with the
+// preconditions that a subsequent write must satisfy in order not to overwrite a concurrent update.
+func (s Cloud) read(ctx context.Context, name string) ([]byte, storage.Conditions, error) {
+	rc, err := s.Client.Bucket(s.BucketName).Object(name).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, storage.Conditions{DoesNotExist: true}, nil
+	} else if err != nil {
+		return nil, storage.Conditions{}, err
+	}
+	defer rc.Close()
+	current, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, storage.Conditions{}, err
+	}
+	return current, storage.Conditions{GenerationMatch: rc.Attrs.Generation}, nil
+}
+
+func (s Cloud) write(ctx context.Context, obj *storage.ObjectHandle, value []byte) error {
+	wc := obj.NewWriter(ctx)
+	_, err := wc.Write(value)
+	if err != nil {
+		wc.Close()
+		return err
+	}
+	return wc.Close()
+}
+
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
@@ -1,35 +1,295 @@
 package tagstore
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
-	"path"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 )
 
 // File is an implementation of TagStore using the local file system, rooted at the specified
-// directory.
+// directory. Namespaces are stored as subdirectories, so a tag cannot have the same name as a
+// namespace, e.g. "team/app" and "team/app/release" cannot both exist.
 type File struct {
 	DirName string
 }
 
 func (s File) Set(ctx context.Context, name string, value []byte) error {
-	return ioutil.WriteFile(path.Join(s.DirName, name), value, 0644)
+	unlock, err := s.lock(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := s.Get(ctx, name)
+	if err == ErrNotFound {
+		current = nil
+	} else if err != nil {
+		return err
+	}
+	return s.update(name, current, value)
 }
 
 func (s File) Get(ctx context.Context, name string) ([]byte, error) {
-	return ioutil.ReadFile(path.Join(s.DirName, name))
+	err := checkName(name)
+	if err != nil {
+		return nil, err
+	}
+	p := s.tagPath(name)
+	b, err := ioutil.ReadFile(p)
+	if isNotFound(p, err) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+func (s File) CompareAndSet(ctx context.Context, name string, expected []byte, value []byte) error {
+	unlock, err := s.lock(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := s.Get(ctx, name)
+	if err == ErrNotFound {
+		current = nil
+	} else if err != nil {
+		return err
+	}
+	if (expected == nil) != (current == nil) || !bytes.Equal(expected, current) {
+		return &ConflictError{
+			Name:     name,
+			Expected: expected,
+			Actual:   current,
+		}
+	}
+	return s.update(name, current, value)
 }
 
-func (s File) List(ctx context.Context) ([]string, error) {
-	files, err := ioutil.ReadDir(s.DirName)
+func (s File) Delete(ctx context.Context, name string) error {
+	unlock, err := s.lock(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := s.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(s.tagPath(name))
+	if err != nil {
+		return err
+	}
+	return s.appendHistory(name, current, nil)
+}
+
+func (s File) List(ctx context.Context, prefix string, after string, limit int) ([]string, error) {
+	names := []string{}
+	err := filepath.Walk(s.DirName, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// Skip lock and temporary files, the history directory, and reserved tags.
+		if p != s.DirName && strings.HasPrefix(fi.Name(), ".") {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Mode().IsRegular() {
+			name, err := filepath.Rel(s.DirName, p)
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(name))
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	// Walk visits files in lexical order within each directory, which is not the same as the order
+	// of the full names, e.g. "a/b" sorts after "a-b".
+	sort.Strings(names)
+	return filterNames(names, prefix, after, limit), nil
+}
 
-	fileNames := []string{}
-	for _, file := range files {
-		fileNames = append(fileNames, file.Name())
+// History reads the history of the tag from a file containing one JSON-encoded entry per line.
+func (s File) History(ctx context.Context, name string) ([]HistoryEntry, error) {
+	err := checkName(name)
+	if err != nil {
+		return nil, err
+	}
+	entries := []HistoryEntry{}
+	p := s.historyPath(name)
+	b, err := ioutil.ReadFile(p)
+	if isNotFound(p, err) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	for {
+		var entry HistoryEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
 	}
+	return entries, nil
+}
+
+func (s File) tagPath(name string) string {
+	return filepath.Join(s.DirName, filepath.FromSlash(name))
+}
+
+func (s File) historyPath(name string) string {
+	return filepath.Join(s.DirName, historyDirName, filepath.FromSlash(name))
+}
+
+// makeParentDir creates the directory containing the file at p, which stores the tag or its
+// history, and checks that p is not itself a directory, i.e. that the name of the tag is not that of
+// a namespace.
+func makeParentDir(name string, p string) error {
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if errors.Is(err, syscall.ENOTDIR) || os.IsExist(err) {
+		return fmt.Errorf("%w %q: a tag already exists with the name of one of its namespaces", ErrInvalidName, name)
+	} else if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+		return fmt.Errorf("%w %q: a namespace already exists with the same name", ErrInvalidName, name)
+	}
+	return nil
+}
 
-	return fileNames, nil
+// isNotFound returns whether err, returned by reading the file at p, means that the tag does not
+// exist, either because the file or one of its parent directories does not exist, or because a
+// namespace rather than a tag has the specified name.
+func isNotFound(p string, err error) bool {
+	if err == nil {
+		return false
+	}
+	if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+		return true
+	}
+	fi, statErr := os.Stat(p)
+	return statErr == nil && fi.IsDir()
+}
+
+// update writes the new value of the tag and appends an entry to its history. It must be called
+// while holding the lock.
+func (s File) update(name string, previous []byte, value []byte) error {
+	// Check that the history can be written before updating the tag, so that they stay consistent.
+	err := makeParentDir(name, s.historyPath(name))
+	if err != nil {
+		return err
+	}
+	err = s.write(name, value)
+	if err != nil {
+		return err
+	}
+	return s.appendHistory(name, previous, value)
+}
+
+// appendHistory appends an entry to the history of the tag. It must be called while holding the
+// lock.
+func (s File) appendHistory(name string, previous []byte, value []byte) error {
+	err := makeParentDir(name, s.historyPath(name))
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.historyPath(name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(HistoryEntry{
+		Time:     time.Now().UTC(),
+		Previous: previous,
+		Value:    value,
+	})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// write atomically replaces the value of the tag, so that readers never observe a partial write.
+func (s File) write(name string, value []byte) error {
+	f, err := ioutil.TempFile(s.DirName, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(value)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(f.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.tagPath(name))
+}
+
+// lock acquires an exclusive lock on the tag with flock(2) on a lock file next to it, waiting until
+// any other process holding it releases it. It returns a function that releases the lock. Lock
+// files are left in place, since removing them would let another process lock a new file while the
+// old one is still locked; the locks themselves are released by the kernel if a process crashes.
+//
+// It also checks the name, and creates the directory of its namespace, so that all the methods that
+// update a tag start by calling it.
+func (s File) lock(ctx context.Context, name string) (func(), error) {
+	err := checkName(name)
+	if err != nil {
+		return nil, err
+	}
+	tagPath := s.tagPath(name)
+	err = makeParentDir(name, tagPath)
+	if err != nil {
+		return nil, err
+	}
+	lockPath := filepath.Join(filepath.Dir(tagPath), "."+filepath.Base(tagPath)+".lock")
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return func() {
+				syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+				f.Close()
+			}, nil
+		}
+		if err != syscall.EWOULDBLOCK && err != syscall.EINTR {
+			f.Close()
+			return nil, fmt.Errorf("could not lock %s: %w", lockPath, err)
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
 }
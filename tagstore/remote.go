@@ -0,0 +1,194 @@
+package tagstore
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/ent/utils"
+)
+
+// Remote is an implementation of TagStore using the tag API of a server. Reads are retried, but
+// updates are not, since they are not idempotent.
+type Remote struct {
+	APIURL string
+	// Token, if set, is sent as a bearer token with every request.
+	Token string
+	// Client is used to send requests; if nil, utils.DefaultHTTPClient is used.
+	Client *http.Client
+	// MaxRetries is the number of times reads are retried; see utils.HTTPClient.
+	MaxRetries int
+}
+
+// ListPageSize is the maximum number of tag names returned by a single request to list tags.
+const ListPageSize = 1000
+
+// ListTagsResponse lists the names of the tags matching the request, except reserved ones.
+type ListTagsResponse struct {
+	Tags []string
+}
+
+// TagHistoryEntry records a single update to a tag; Previous is empty if the tag did not exist,
+// and Value is empty if the tag was deleted.
+type TagHistoryEntry struct {
+	Time     time.Time
+	Previous string
+	Value    string
+}
+
+func (s Remote) client() utils.HTTPClient {
+	return utils.HTTPClient{
+		Client:     s.Client,
+		Token:      s.Token,
+		MaxRetries: s.MaxRetries,
+	}
+}
+
+// tagURL escapes slashes in the name, so that the path has a single segment for the name.
+func (s Remote) tagURL(name string) string {
+	return s.APIURL + "/api/tags/" + url.PathEscape(name)
+}
+
+// checkStatus closes the body of the response and returns an error if its status is not 2xx.
+func checkStatus(res *http.Response) error {
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return ErrNotFound
+	}
+	err := utils.CheckStatus(res)
+	if err != nil {
+		res.Body.Close()
+	}
+	return err
+}
+
+func (s Remote) get(ctx context.Context, u string) ([]byte, error) {
+	res, err := s.client().Do(ctx, http.MethodGet, u, nil, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	err = checkStatus(res)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+func (s Remote) Set(ctx context.Context, name string, value []byte) error {
+	res, err := s.client().Do(ctx, http.MethodPost, s.tagURL(name), nil, value, false)
+	if err != nil {
+		return err
+	}
+	err = checkStatus(res)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (s Remote) Get(ctx context.Context, name string) ([]byte, error) {
+	return s.get(ctx, s.tagURL(name))
+}
+
+// CompareAndSet uses the If-Match and If-None-Match headers, so that the server checks the current
+// value. The returned *ConflictError does not include the actual value.
+func (s Remote) CompareAndSet(ctx context.Context, name string, expected []byte, value []byte) error {
+	header := http.Header{}
+	if expected == nil {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", ETag(expected))
+	}
+	res, err := s.client().Do(ctx, http.MethodPost, s.tagURL(name), header, value, false)
+	if err != nil {
+		return err
+	}
+	// The server returns 409 if the sequence number of a signed value is not greater than the
+	// current one.
+	if res.StatusCode == http.StatusPreconditionFailed || res.StatusCode == http.StatusConflict {
+		res.Body.Close()
+		return &ConflictError{
+			Name:     name,
+			Expected: expected,
+		}
+	}
+	err = checkStatus(res)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// List fetches the names in pages of at most ListPageSize names, which is also the maximum the server
+// returns per request.
+func (s Remote) List(ctx context.Context, prefix string, after string, limit int) ([]string, error) {
+	names := []string{}
+	for limit <= 0 || len(names) < limit {
+		pageSize := ListPageSize
+		if limit > 0 && limit-len(names) < pageSize {
+			pageSize = limit - len(names)
+		}
+		query := url.Values{}
+		query.Set("prefix", prefix)
+		query.Set("after", after)
+		query.Set("limit", strconv.Itoa(pageSize))
+		b, err := s.get(ctx, s.APIURL+"/api/tags?"+query.Encode())
+		if err != nil {
+			return nil, err
+		}
+		var response ListTagsResponse
+		err = json.Unmarshal(b, &response)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, response.Tags...)
+		if len(response.Tags) < pageSize {
+			break
+		}
+		after = response.Tags[len(response.Tags)-1]
+	}
+	return names, nil
+}
+
+func (s Remote) History(ctx context.Context, name string) ([]HistoryEntry, error) {
+	b, err := s.get(ctx, s.tagURL(name)+"/history")
+	if err != nil {
+		return nil, err
+	}
+	var response []TagHistoryEntry
+	err = json.Unmarshal(b, &response)
+	if err != nil {
+		return nil, err
+	}
+	entries := []HistoryEntry{}
+	for _, e := range response {
+		entry := HistoryEntry{
+			Time: e.Time,
+		}
+		if e.Previous != "" {
+			entry.Previous = []byte(e.Previous)
+		}
+		if e.Value != "" {
+			entry.Value = []byte(e.Value)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (s Remote) Delete(ctx context.Context, name string) error {
+	res, err := s.client().Do(ctx, http.MethodDelete, s.tagURL(name), nil, nil, false)
+	if err != nil {
+		return err
+	}
+	err = checkStatus(res)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
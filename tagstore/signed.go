@@ -0,0 +1,112 @@
+package tagstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/multiformats/go-multihash"
+)
+
+// SignedTag is a statement, signed with the ed25519 key PublicKey, that the tag Name points to
+// Value. It is stored JSON-encoded as the value of the tag; unsigned tag values are bare CIDs or
+// hashes instead.
+type SignedTag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// Seq increases with every update to the tag, so that older statements cannot be replayed.
+	Seq       uint64    `json:"seq"`
+	Timestamp time.Time `json:"timestamp"`
+	PublicKey []byte    `json:"public_key"`
+	Signature []byte    `json:"signature"`
+}
+
+// Sign returns a statement that the tag name points to value, signed with key.
+func Sign(key ed25519.PrivateKey, name string, value string, seq uint64, timestamp time.Time) *SignedTag {
+	t := &SignedTag{
+		Name:      name,
+		Value:     value,
+		Seq:       seq,
+		Timestamp: timestamp.UTC(),
+		PublicKey: key.Public().(ed25519.PublicKey),
+	}
+	t.Signature = ed25519.Sign(key, t.signedBytes())
+	return t
+}
+
+// signedBytes returns the message covered by the signature. Fields are quoted so that their
+// boundaries are unambiguous.
+func (t *SignedTag) signedBytes() []byte {
+	return []byte(fmt.Sprintf("ent signed tag v1\nname: %q\nvalue: %q\nseq: %d\ntimestamp: %d\n", t.Name, t.Value, t.Seq, t.Timestamp.UnixNano()))
+}
+
+// Verify checks that the statement is about the tag name and that its signature is valid; it does
+// not check whether the key that made it is trusted.
+func (t *SignedTag) Verify(name string) error {
+	if t.Name != name {
+		return fmt.Errorf("signed tag is for %q instead of %q", t.Name, name)
+	}
+	if len(t.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key")
+	}
+	if !ed25519.Verify(ed25519.PublicKey(t.PublicKey), t.signedBytes(), t.Signature) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// Marshal returns the encoding of the statement as a tag value.
+func (t *SignedTag) Marshal() ([]byte, error) {
+	return json.Marshal(t)
+}
+
+// ParseValue returns the CID or hash that a tag value points to, and the signed statement if the
+// value is signed, or nil otherwise. The signature is not verified.
+func ParseValue(value []byte) (string, *SignedTag, error) {
+	if !bytes.HasPrefix(value, []byte("{")) {
+		return string(value), nil, nil
+	}
+	var t SignedTag
+	err := json.Unmarshal(value, &t)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not parse signed tag: %v", err)
+	}
+	return t.Value, &t, nil
+}
+
+// ObjectGetter is the part of a node service that ParseRoot needs.
+type ObjectGetter interface {
+	GetObject(ctx context.Context, h multihash.Multihash) ([]byte, error)
+}
+
+// ParseRoot parses a tag value into a root. Tags set by the server contain a CID, while tags set by
+// `ent push` contain a hex-encoded multihash, in which case the codec is inferred by fetching the
+// object from objects and checking whether it parses as a DAG-PB node. Signed tags contain a CID;
+// their signature is not verified.
+func ParseRoot(ctx context.Context, objects ObjectGetter, value []byte) (cid.Cid, error) {
+	target, _, err := ParseValue(value)
+	if err != nil {
+		return cid.Undef, err
+	}
+	c, err := cid.Decode(target)
+	if err == nil {
+		return c, nil
+	}
+	h, err := multihash.FromHexString(target)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("invalid tag value %q", target)
+	}
+	b, err := objects.GetObject(ctx, h)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if _, err := merkledag.DecodeProtobuf(b); err == nil {
+		return cid.NewCidV1(cid.DagProtobuf, h), nil
+	}
+	return cid.NewCidV1(cid.Raw, h), nil
+}
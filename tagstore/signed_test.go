@@ -0,0 +1,67 @@
+package tagstore
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+)
+
+func newTestKey(t *testing.T) ed25519.PrivateKey {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSignVerify(t *testing.T) {
+	key := newTestKey(t)
+	signed := Sign(key, "team/app", "bafkreiabc", 3, time.Now())
+	err := signed.Verify("team/app")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	b, err := signed.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, parsed, err := ParseValue(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "bafkreiabc" || parsed == nil {
+		t.Fatalf("ParseValue = %q, %v", value, parsed)
+	}
+	err = parsed.Verify("team/app")
+	if err != nil {
+		t.Errorf("Verify after ParseValue: %v", err)
+	}
+}
+
+func TestVerifyRejectsChanges(t *testing.T) {
+	key := newTestKey(t)
+	other := newTestKey(t)
+	tests := []struct {
+		name   string
+		change func(s *SignedTag)
+		verify string
+	}{
+		{name: "wrong key", change: func(s *SignedTag) { s.PublicKey = other.Public().(ed25519.PublicKey) }, verify: "team/app"},
+		{name: "other tag", change: func(s *SignedTag) {}, verify: "team/other"},
+		{name: "changed name", change: func(s *SignedTag) { s.Name = "team/other" }, verify: "team/other"},
+		{name: "changed value", change: func(s *SignedTag) { s.Value = "bafkreixyz" }, verify: "team/app"},
+		{name: "changed seq", change: func(s *SignedTag) { s.Seq++ }, verify: "team/app"},
+		{name: "changed timestamp", change: func(s *SignedTag) { s.Timestamp = s.Timestamp.Add(time.Second) }, verify: "team/app"},
+		{name: "truncated key", change: func(s *SignedTag) { s.PublicKey = s.PublicKey[:16] }, verify: "team/app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed := Sign(key, "team/app", "bafkreiabc", 3, time.Now())
+			tt.change(signed)
+			if signed.Verify(tt.verify) == nil {
+				t.Errorf("Verify accepted the statement")
+			}
+		})
+	}
+}
@@ -1,10 +1,122 @@
 package tagstore
 
-import "context"
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrNotFound = fmt.Errorf("not found")
+	// ErrInvalidName is wrapped by the errors returned for invalid tag names.
+	ErrInvalidName = fmt.Errorf("invalid tag name")
+)
 
 type TagStore interface {
 	Set(ctx context.Context, name string, value []byte) error
 	Get(ctx context.Context, name string) ([]byte, error)
-	// TODO: Support prefix.
-	List(ctx context.Context) ([]string, error)
+	// CompareAndSet sets the tag to value only if its current value is expected or, if expected is
+	// nil, only if the tag does not exist yet. Otherwise it returns a *ConflictError.
+	CompareAndSet(ctx context.Context, name string, expected []byte, value []byte) error
+	// List returns, in lexicographic order, the names of the tags that start with prefix and sort
+	// after the given name, up to limit names if limit is positive. Reserved tags are not listed.
+	List(ctx context.Context, prefix string, after string, limit int) ([]string, error)
+	// History returns all the recorded updates to the tag, oldest first.
+	History(ctx context.Context, name string) ([]HistoryEntry, error)
+	// Delete removes the tag, or returns ErrNotFound if it does not exist.
+	Delete(ctx context.Context, name string) error
+}
+
+// HistoryEntry records a single update to a tag.
+type HistoryEntry struct {
+	Time time.Time
+	// Previous is nil if the tag did not exist before the update.
+	Previous []byte
+	// Value is nil if the tag was deleted.
+	Value []byte
+}
+
+// ValidateName returns an error wrapping ErrInvalidName if name is not a valid name for a
+// user-defined tag.
+//
+// Names are made of one or more segments separated by "/", which group tags into namespaces, e.g.
+// "team/app/release". Segments must not be empty, start with ".", or contain control characters or
+// backslashes. Names starting with "." are reserved for internal tags, such as the head of the
+// transparency log.
+func ValidateName(name string) error {
+	if IsReserved(name) {
+		return fmt.Errorf("%w %q: names starting with \".\" are reserved", ErrInvalidName, name)
+	}
+	return checkName(name)
+}
+
+// IsReserved returns whether name is reserved for internal tags.
+func IsReserved(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// checkName is like ValidateName, but also accepts reserved names. Stores use it to ensure that
+// names map to well-formed paths.
+func checkName(name string) error {
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("%w %q: not valid UTF-8", ErrInvalidName, name)
+	}
+	for _, segment := range strings.Split(strings.TrimPrefix(name, "."), "/") {
+		if segment == "" {
+			return fmt.Errorf("%w %q: empty segment", ErrInvalidName, name)
+		}
+		// The leading dot of reserved names has already been trimmed from the first segment, so this
+		// also rejects "." and "..".
+		if strings.HasPrefix(segment, ".") {
+			return fmt.Errorf("%w %q: segment %q starts with \".\"", ErrInvalidName, name, segment)
+		}
+		if strings.IndexFunc(segment, func(r rune) bool { return unicode.IsControl(r) || r == '\\' }) >= 0 {
+			return fmt.Errorf("%w %q: segment %q contains invalid characters", ErrInvalidName, name, segment)
+		}
+	}
+	return nil
+}
+
+// filterNames applies the List semantics to an already sorted slice of names.
+func filterNames(names []string, prefix string, after string, limit int) []string {
+	out := []string{}
+	for _, n := range names {
+		if !strings.HasPrefix(n, prefix) || IsReserved(n) || n <= after {
+			continue
+		}
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// historyDirName is the directory (or object name prefix) under which the history of each tag is
+// stored, separately from the tags themselves.
+const historyDirName = ".history"
+
+// ConflictError is returned by CompareAndSet when the tag does not have the expected value.
+type ConflictError struct {
+	Name     string
+	Expected []byte
+	// Actual is the value of the tag at the time of the update, or nil if the tag does not exist or
+	// the value is not known.
+	Actual []byte
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflicting update to tag %q: expected %q, actual %q", e.Name, e.Expected, e.Actual)
+}
+
+// ETag returns the HTTP entity tag corresponding to a tag value, used for conditional updates. Tag
+// values may contain quotes, so they are hashed rather than used directly.
+func ETag(value []byte) string {
+	h := sha256.Sum256(value)
+	return `"` + hex.EncodeToString(h[:]) + `"`
 }
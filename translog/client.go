@@ -0,0 +1,95 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translog
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/google/ent/utils"
+)
+
+// Client fetches tree heads and proofs from the log of a remote server.
+type Client struct {
+	APIURL string
+	// Token, if set, is sent as a bearer token with every request.
+	Token string
+}
+
+func (c Client) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	u := c.APIURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	client := utils.HTTPClient{
+		Token: c.Token,
+	}
+	res, err := client.Do(ctx, http.MethodGet, u, nil, nil, true)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	err = utils.CheckStatus(res)
+	if err != nil {
+		return err
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// TreeHead returns the latest signed tree head of the log; its signature is not verified.
+func (c Client) TreeHead(ctx context.Context) (*SignedTreeHead, error) {
+	var h SignedTreeHead
+	err := c.get(ctx, "/api/log/sth", nil, &h)
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// InclusionProof returns the proof that the leaf with the given hash is included in the tree with
+// the given size, or ErrNotFound if it is not.
+func (c Client) InclusionProof(ctx context.Context, leafHash []byte, treeSize uint64) (*InclusionProof, error) {
+	var p InclusionProof
+	err := c.get(ctx, "/api/log/inclusion", url.Values{
+		"leaf_hash": {hex.EncodeToString(leafHash)},
+		"tree_size": {strconv.FormatUint(treeSize, 10)},
+	}, &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ConsistencyProof returns the proof that the tree with first leaves is a prefix of the tree with
+// second leaves.
+func (c Client) ConsistencyProof(ctx context.Context, first uint64, second uint64) (*ConsistencyProof, error) {
+	var p ConsistencyProof
+	err := c.get(ctx, "/api/log/consistency", url.Values{
+		"first":  {strconv.FormatUint(first, 10)},
+		"second": {strconv.FormatUint(second, 10)},
+	}, &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
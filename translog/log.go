@@ -0,0 +1,337 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translog
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/ent/nodeservice"
+	"github.com/google/ent/tagstore"
+	"github.com/google/ent/utils"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+)
+
+// HeadTag is the name of the tag pointing to the latest state of the log. Tag names starting with
+// a dot are reserved, and not listed.
+const HeadTag = ".log"
+
+// appendAttempts is the number of times an append is retried if the log is concurrently updated,
+// e.g. by another server instance.
+const appendAttempts = 10
+
+var ErrNotFound = fmt.Errorf("not found")
+
+// Entry is a single tag update recorded in the log; its JSON encoding is the data of the
+// corresponding leaf of the tree.
+type Entry struct {
+	Name string `json:"name"`
+	// Value is the new value of the tag, e.g. a CID or a JSON-encoded tagstore.SignedTag.
+	Value string `json:"value"`
+}
+
+// LeafHash returns the hash of the leaf of the tree corresponding to the entry.
+func (e Entry) LeafHash() ([]byte, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return HashLeaf(b), nil
+}
+
+// stateData is the data of the DAG-PB node recording the state of the log after each append. The
+// node links to the raw node containing the appended entry, and to the previous state, if any, so
+// that the whole log is reachable from the head, including for garbage collection.
+type stateData struct {
+	Size uint64 `json:"size"`
+}
+
+const (
+	entryLink    = "entry"
+	previousLink = "previous"
+)
+
+// SignedTreeHead is a statement, signed by the log, of the size and root hash of the tree at a
+// given time.
+type SignedTreeHead struct {
+	TreeSize  uint64    `json:"tree_size"`
+	RootHash  []byte    `json:"root_hash"`
+	Timestamp time.Time `json:"timestamp"`
+	Signature []byte    `json:"signature"`
+}
+
+func (h *SignedTreeHead) signedBytes() []byte {
+	return []byte(fmt.Sprintf("ent tree head v1\nsize: %d\nroot: %x\ntimestamp: %d\n", h.TreeSize, h.RootHash, h.Timestamp.UnixNano()))
+}
+
+// Verify checks that the tree head is signed with the given key.
+func (h *SignedTreeHead) Verify(key ed25519.PublicKey) error {
+	if !ed25519.Verify(key, h.signedBytes(), h.Signature) {
+		return fmt.Errorf("invalid tree head signature")
+	}
+	return nil
+}
+
+type InclusionProof struct {
+	LeafIndex uint64   `json:"leaf_index"`
+	TreeSize  uint64   `json:"tree_size"`
+	Hashes    [][]byte `json:"hashes"`
+}
+
+type ConsistencyProof struct {
+	First  uint64   `json:"first"`
+	Second uint64   `json:"second"`
+	Hashes [][]byte `json:"hashes"`
+}
+
+// Log is a transparency log whose entries and states are stored as nodes in Nodes, and whose
+// latest state is pointed to by HeadTag in Tags. Leaf hashes are kept in memory, and reloaded when
+// the log is updated by someone else.
+type Log struct {
+	Nodes nodeservice.NodeService
+	Tags  tagstore.TagStore
+	// Key signs tree heads.
+	Key ed25519.PrivateKey
+
+	mu sync.Mutex
+	// head is the value of HeadTag corresponding to leaves, or nil if the log is empty.
+	head   []byte
+	leaves [][]byte
+	// index maps each leaf hash to the index at which it first appears.
+	index map[string]int
+}
+
+// sync loads any entries appended since the last time the log was read. It must be called with mu
+// held.
+func (l *Log) sync(ctx context.Context) error {
+	head, err := l.Tags.Get(ctx, HeadTag)
+	if err == tagstore.ErrNotFound {
+		head = nil
+	} else if err != nil {
+		return fmt.Errorf("could not get log head: %v", err)
+	}
+	if bytes.Equal(head, l.head) && l.index != nil {
+		return nil
+	}
+
+	// Walk back from the new head until reaching the state that is already loaded, if any.
+	newLeaves := [][]byte{}
+	known := l.index != nil
+	next := head
+	for next != nil && !(known && bytes.Equal(next, l.head)) {
+		c, err := cid.Decode(string(next))
+		if err != nil {
+			return fmt.Errorf("invalid log state %q: %v", next, err)
+		}
+		leaf, previous, err := l.getState(ctx, c)
+		if err != nil {
+			return err
+		}
+		newLeaves = append(newLeaves, leaf)
+		next = nil
+		if previous.Defined() {
+			next = []byte(previous.String())
+		}
+	}
+	if next == nil {
+		// The new head does not extend the loaded state, so reload the log from scratch.
+		l.leaves = nil
+		l.index = map[string]int{}
+	}
+	for i := len(newLeaves) - 1; i >= 0; i-- {
+		l.add(newLeaves[i])
+	}
+	l.head = head
+	return nil
+}
+
+func (l *Log) add(leaf []byte) {
+	if _, ok := l.index[string(leaf)]; !ok {
+		l.index[string(leaf)] = len(l.leaves)
+	}
+	l.leaves = append(l.leaves, leaf)
+}
+
+// getState returns the leaf hash of the entry appended by the state node with the given id, and
+// the id of the previous state, or cid.Undef if it is the first one.
+func (l *Log) getState(ctx context.Context, c cid.Cid) ([]byte, cid.Cid, error) {
+	node, err := l.Nodes.Get(ctx, c)
+	if err != nil {
+		return nil, cid.Undef, fmt.Errorf("could not get log state %s: %v", c, err)
+	}
+	state, ok := node.(*merkledag.ProtoNode)
+	if !ok {
+		return nil, cid.Undef, fmt.Errorf("invalid log state %s", c)
+	}
+	entryCid, err := utils.GetLink(state, entryLink)
+	if err != nil {
+		return nil, cid.Undef, fmt.Errorf("invalid log state %s: %v", c, err)
+	}
+	entry, err := l.Nodes.Get(ctx, entryCid)
+	if err != nil {
+		return nil, cid.Undef, fmt.Errorf("could not get log entry %s: %v", entryCid, err)
+	}
+	previous, err := utils.GetLink(state, previousLink)
+	if err == merkledag.ErrLinkNotFound {
+		previous = cid.Undef
+	} else if err != nil {
+		return nil, cid.Undef, fmt.Errorf("invalid log state %s: %v", c, err)
+	}
+	return HashLeaf(entry.RawData()), previous, nil
+}
+
+// Append applies an update to a tag by calling apply, and records it in the log if apply succeeds;
+// otherwise, it returns the error of apply as is, and nothing is logged. Tree heads and proofs are
+// not served while the update is applied and logged, so that any tree head fetched after the new
+// value is visible includes it.
+func (l *Log) Append(ctx context.Context, name string, value []byte, apply func() error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := apply()
+	if err != nil {
+		return err
+	}
+
+	entryBytes, err := json.Marshal(Entry{
+		Name:  name,
+		Value: string(value),
+	})
+	if err != nil {
+		return err
+	}
+	entry, err := utils.ParseRawNode(entryBytes)
+	if err != nil {
+		return err
+	}
+	err = l.Nodes.Add(ctx, entry)
+	if err != nil {
+		return fmt.Errorf("could not add log entry: %v", err)
+	}
+
+	for i := 0; i < appendAttempts; i++ {
+		err := l.sync(ctx)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(stateData{
+			Size: uint64(len(l.leaves)) + 1,
+		})
+		if err != nil {
+			return err
+		}
+		state := utils.NewProtoNode()
+		state.SetData(data)
+		err = utils.SetLink(state, entryLink, entry.Cid())
+		if err != nil {
+			return err
+		}
+		if l.head != nil {
+			previous, err := cid.Decode(string(l.head))
+			if err != nil {
+				return err
+			}
+			err = utils.SetLink(state, previousLink, previous)
+			if err != nil {
+				return err
+			}
+		}
+		err = l.Nodes.Add(ctx, state)
+		if err != nil {
+			return fmt.Errorf("could not add log state: %v", err)
+		}
+		head := []byte(state.Cid().String())
+		err = l.Tags.CompareAndSet(ctx, HeadTag, l.head, head)
+		if _, ok := err.(*tagstore.ConflictError); ok {
+			// Someone else appended to the log in the meantime.
+			continue
+		} else if err != nil {
+			return fmt.Errorf("could not update log head: %v", err)
+		}
+		l.add(HashLeaf(entryBytes))
+		l.head = head
+		return nil
+	}
+	return fmt.Errorf("could not append to log after %d attempts", appendAttempts)
+}
+
+// TreeHead returns the current tree head, signed with Key.
+func (l *Log) TreeHead(ctx context.Context) (*SignedTreeHead, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.sync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	h := &SignedTreeHead{
+		TreeSize:  uint64(len(l.leaves)),
+		RootHash:  RootHash(l.leaves),
+		Timestamp: time.Now().UTC(),
+	}
+	h.Signature = ed25519.Sign(l.Key, h.signedBytes())
+	return h, nil
+}
+
+// InclusionProof returns the proof that the leaf with the given hash is included in the tree with
+// the given size, or ErrNotFound if it is not.
+func (l *Log) InclusionProof(ctx context.Context, leafHash []byte, treeSize uint64) (*InclusionProof, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.sync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if treeSize > uint64(len(l.leaves)) {
+		return nil, fmt.Errorf("tree size %d is larger than the log", treeSize)
+	}
+	index, ok := l.index[string(leafHash)]
+	if !ok || uint64(index) >= treeSize {
+		return nil, ErrNotFound
+	}
+	return &InclusionProof{
+		LeafIndex: uint64(index),
+		TreeSize:  treeSize,
+		Hashes:    ProveInclusion(l.leaves[:treeSize], index),
+	}, nil
+}
+
+// ConsistencyProof returns the proof that the tree with first leaves is a prefix of the tree with
+// second leaves.
+func (l *Log) ConsistencyProof(ctx context.Context, first uint64, second uint64) (*ConsistencyProof, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.sync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if first > second || second > uint64(len(l.leaves)) {
+		return nil, fmt.Errorf("invalid tree sizes %d and %d for log of size %d", first, second, len(l.leaves))
+	}
+	return &ConsistencyProof{
+		First:  first,
+		Second: second,
+		Hashes: ProveConsistency(l.leaves[:second], int(first)),
+	}, nil
+}
@@ -0,0 +1,190 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package translog implements an append-only transparency log of tag updates, as a Merkle tree
+// following RFC 6962, whose entries are stored as objects.
+package translog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+// HashLeaf returns the hash of a leaf of the tree with the given data.
+func HashLeaf(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func hashChildren(left []byte, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// splitPoint returns the largest power of two smaller than n, for n > 1.
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// RootHash returns the root hash of the tree with the given leaf hashes.
+func RootHash(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		h := sha256.Sum256(nil)
+		return h[:]
+	case 1:
+		return leaves[0]
+	default:
+		k := splitPoint(len(leaves))
+		return hashChildren(RootHash(leaves[:k]), RootHash(leaves[k:]))
+	}
+}
+
+// ProveInclusion returns the audit path of the leaf at index in the tree with the given leaf
+// hashes.
+func ProveInclusion(leaves [][]byte, index int) [][]byte {
+	if len(leaves) <= 1 {
+		return [][]byte{}
+	}
+	k := splitPoint(len(leaves))
+	if index < k {
+		return append(ProveInclusion(leaves[:k], index), RootHash(leaves[k:]))
+	}
+	return append(ProveInclusion(leaves[k:], index-k), RootHash(leaves[:k]))
+}
+
+// ProveConsistency returns the proof that the tree with the first size leaves is a prefix of the
+// tree with the given leaf hashes.
+func ProveConsistency(leaves [][]byte, size int) [][]byte {
+	if size == 0 || size == len(leaves) {
+		return [][]byte{}
+	}
+	return subProof(leaves, size, true)
+}
+
+func subProof(leaves [][]byte, m int, complete bool) [][]byte {
+	if m == len(leaves) {
+		if complete {
+			return [][]byte{}
+		}
+		return [][]byte{RootHash(leaves)}
+	}
+	k := splitPoint(len(leaves))
+	if m <= k {
+		return append(subProof(leaves[:k], m, complete), RootHash(leaves[k:]))
+	}
+	return append(subProof(leaves[k:], m-k, false), RootHash(leaves[:k]))
+}
+
+// VerifyInclusion checks that the leaf hash is at index in the tree of the given size and root
+// hash, as described in RFC 9162, section 2.1.3.2.
+func VerifyInclusion(leafHash []byte, index uint64, size uint64, root []byte, proof [][]byte) error {
+	if index >= size {
+		return fmt.Errorf("leaf index %d out of range for tree size %d", index, size)
+	}
+	fn := index
+	sn := size - 1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return fmt.Errorf("inclusion proof too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			r = hashChildren(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = hashChildren(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return fmt.Errorf("inclusion proof too short")
+	}
+	if !bytes.Equal(r, root) {
+		return fmt.Errorf("inclusion proof does not match root hash")
+	}
+	return nil
+}
+
+// VerifyConsistency checks that the tree with size1 leaves and root hash root1 is a prefix of the
+// tree with size2 leaves and root hash root2, as described in RFC 9162, section 2.1.4.2.
+func VerifyConsistency(size1 uint64, root1 []byte, size2 uint64, root2 []byte, proof [][]byte) error {
+	if size1 > size2 {
+		return fmt.Errorf("tree size decreased from %d to %d", size1, size2)
+	}
+	if size1 == size2 {
+		if len(proof) != 0 || !bytes.Equal(root1, root2) {
+			return fmt.Errorf("trees of size %d have different root hashes", size1)
+		}
+		return nil
+	}
+	if size1 == 0 {
+		// The empty tree is a prefix of any tree.
+		return nil
+	}
+	if size1&(size1-1) == 0 {
+		// The first tree is a complete subtree, whose root is not included in the proof.
+		proof = append([][]byte{root1}, proof...)
+	}
+	if len(proof) == 0 {
+		return fmt.Errorf("empty consistency proof")
+	}
+	fn := size1 - 1
+	sn := size2 - 1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr := proof[0]
+	sr := proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return fmt.Errorf("consistency proof too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = hashChildren(c, fr)
+			sr = hashChildren(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = hashChildren(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return fmt.Errorf("consistency proof too short")
+	}
+	if !bytes.Equal(fr, root1) || !bytes.Equal(sr, root2) {
+		return fmt.Errorf("consistency proof does not match root hashes")
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+const maxTestSize = 64
+
+func testLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = HashLeaf([]byte(fmt.Sprintf("leaf %d", i)))
+	}
+	return leaves
+}
+
+// bruteForceRoot computes the root hash by hashing the tree level by level, promoting the last node
+// of a level unchanged when the level has an odd number of nodes, which yields the same tree as the
+// recursive definition of RFC 6962.
+func bruteForceRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		h := sha256.Sum256(nil)
+		return h[:]
+	}
+	level := leaves
+	for len(level) > 1 {
+		next := [][]byte{}
+		for i := 0; i+1 < len(level); i += 2 {
+			h := sha256.New()
+			h.Write([]byte{1})
+			h.Write(level[i])
+			h.Write(level[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		if len(level)%2 == 1 {
+			next = append(next, level[len(level)-1])
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// tamper returns copies of proof with each hash in turn altered.
+func tamper(proof [][]byte) [][][]byte {
+	tampered := [][][]byte{}
+	for i := range proof {
+		p := make([][]byte, len(proof))
+		copy(p, proof)
+		p[i] = append([]byte{}, proof[i]...)
+		p[i][0] ^= 1
+		tampered = append(tampered, p)
+	}
+	return tampered
+}
+
+func equalProofs(a [][]byte, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRootHash(t *testing.T) {
+	for n := 0; n <= maxTestSize; n++ {
+		leaves := testLeaves(n)
+		if got, want := RootHash(leaves), bruteForceRoot(leaves); !bytes.Equal(got, want) {
+			t.Errorf("RootHash of %d leaves = %x, want %x", n, got, want)
+		}
+	}
+}
+
+func TestInclusion(t *testing.T) {
+	all := testLeaves(maxTestSize)
+	for n := 1; n <= maxTestSize; n++ {
+		leaves := all[:n]
+		root := bruteForceRoot(leaves)
+		size := uint64(n)
+		for i := 0; i < n; i++ {
+			index := uint64(i)
+			proof := ProveInclusion(leaves, i)
+			err := VerifyInclusion(leaves[i], index, size, root, proof)
+			if err != nil {
+				t.Errorf("leaf %d of %d: %v", i, n, err)
+				continue
+			}
+			for _, p := range tamper(proof) {
+				if VerifyInclusion(leaves[i], index, size, root, p) == nil {
+					t.Errorf("leaf %d of %d: tampered proof accepted", i, n)
+				}
+			}
+			if VerifyInclusion(HashLeaf([]byte("other")), index, size, root, proof) == nil {
+				t.Errorf("leaf %d of %d: wrong leaf accepted", i, n)
+			}
+			for s := i + 1; s <= maxTestSize; s++ {
+				if s == n {
+					continue
+				}
+				// Trees of different sizes have different root hashes, so a proof may only be
+				// accepted for another size if it is also the audit path in that tree.
+				other := ProveInclusion(all[:s], i)
+				if VerifyInclusion(leaves[i], index, uint64(s), bruteForceRoot(all[:s]), proof) == nil && !equalProofs(proof, other) {
+					t.Errorf("leaf %d of %d: proof accepted for size %d", i, n, s)
+				}
+				if len(other) != len(proof) && VerifyInclusion(leaves[i], index, uint64(s), root, proof) == nil {
+					t.Errorf("leaf %d of %d: proof accepted for size %d", i, n, s)
+				}
+			}
+			if VerifyInclusion(leaves[i], index, size, root, append(proof, root)) == nil {
+				t.Errorf("leaf %d of %d: extended proof accepted", i, n)
+			}
+			if len(proof) > 0 && VerifyInclusion(leaves[i], index, size, root, proof[:len(proof)-1]) == nil {
+				t.Errorf("leaf %d of %d: truncated proof accepted", i, n)
+			}
+		}
+		if VerifyInclusion(leaves[0], size, size, root, nil) == nil {
+			t.Errorf("size %d: out of range index accepted", n)
+		}
+	}
+}
+
+func TestConsistency(t *testing.T) {
+	all := testLeaves(maxTestSize)
+	for n := 1; n <= maxTestSize; n++ {
+		leaves := all[:n]
+		root := bruteForceRoot(leaves)
+		size := uint64(n)
+		for m := 0; m <= n; m++ {
+			oldRoot := bruteForceRoot(leaves[:m])
+			oldSize := uint64(m)
+			proof := ProveConsistency(leaves, m)
+			err := VerifyConsistency(oldSize, oldRoot, size, root, proof)
+			if err != nil {
+				t.Errorf("%d to %d: %v", m, n, err)
+				continue
+			}
+			if m == 0 {
+				continue
+			}
+			for _, p := range tamper(proof) {
+				if VerifyConsistency(oldSize, oldRoot, size, root, p) == nil {
+					t.Errorf("%d to %d: tampered proof accepted", m, n)
+				}
+			}
+			if VerifyConsistency(oldSize, HashLeaf([]byte("other")), size, root, proof) == nil {
+				t.Errorf("%d to %d: wrong old root accepted", m, n)
+			}
+			if VerifyConsistency(oldSize, oldRoot, size, HashLeaf([]byte("other")), proof) == nil {
+				t.Errorf("%d to %d: wrong new root accepted", m, n)
+			}
+			for s := m; s <= maxTestSize; s++ {
+				if s == n {
+					continue
+				}
+				other := ProveConsistency(all[:s], m)
+				if VerifyConsistency(oldSize, oldRoot, uint64(s), bruteForceRoot(all[:s]), proof) == nil && !equalProofs(proof, other) {
+					t.Errorf("%d to %d: proof accepted for new size %d", m, n, s)
+				}
+				if len(other) != len(proof) && VerifyConsistency(oldSize, oldRoot, uint64(s), root, proof) == nil {
+					t.Errorf("%d to %d: proof accepted for new size %d", m, n, s)
+				}
+			}
+			for s := 1; s <= n; s++ {
+				if s != m && VerifyConsistency(uint64(s), bruteForceRoot(all[:s]), size, root, proof) == nil {
+					t.Errorf("%d to %d: proof accepted for old size %d", m, n, s)
+				}
+			}
+			if m < n && VerifyConsistency(size, root, oldSize, oldRoot, proof) == nil {
+				t.Errorf("%d to %d: proof accepted for decreasing sizes", m, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+)
+
+// ChunkSize is the size of the chunks into which large files are split. Files up to this size are
+// stored as a single raw node.
+const ChunkSize = 1 << 20
+
+// chunkedFileData is the data of DAG-PB nodes representing chunked files, which distinguishes them
+// from directories. Their links are unnamed, and point to the raw chunks in order.
+var chunkedFileData = []byte("ent:chunked-file")
+
+func NewChunkedFileNode() *merkledag.ProtoNode {
+	node := NewProtoNode()
+	node.SetData(chunkedFileData)
+	return node
+}
+
+// AddChunk appends a chunk of the given size to a chunked file node.
+func AddChunk(node *merkledag.ProtoNode, chunk cid.Cid, size int) error {
+	return node.AddRawLink("", &format.Link{
+		Cid:  chunk,
+		Size: uint64(size),
+	})
+}
+
+func IsChunkedFile(node format.Node) bool {
+	protoNode, ok := node.(*merkledag.ProtoNode)
+	return ok && bytes.Equal(protoNode.Data(), chunkedFileData)
+}
+
+// FileSize returns the size of the content of a file node, which is either a raw node or a chunked
+// file node.
+func FileSize(node format.Node) uint64 {
+	if IsChunkedFile(node) {
+		size := uint64(0)
+		for _, l := range node.Links() {
+			size += l.Size
+		}
+		return size
+	}
+	return uint64(len(node.RawData()))
+}
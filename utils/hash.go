@@ -0,0 +1,145 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"lukechampine.com/blake3"
+)
+
+// BLAKE3 is the multihash code of BLAKE3, which is not defined by the version of go-multihash in
+// use, and is registered with it by this package.
+const BLAKE3 = 0x1e
+
+func init() {
+	multihash.Register(BLAKE3, func() hash.Hash { return blake3.New(32, nil) })
+}
+
+// DefaultHashType is the multihash function used for new content, such as nodes created from local
+// files, or objects uploaded without specifying a hash function. Existing content always keeps the
+// hash function of its CID.
+var DefaultHashType uint64 = multihash.SHA2_256
+
+// hashNames lists the supported hash functions.
+var hashNames = map[string]uint64{
+	"sha2-256": multihash.SHA2_256,
+	"sha2-512": multihash.SHA2_512,
+	"blake3":   BLAKE3,
+}
+
+// ParseHashType returns the multihash code of a supported hash function, e.g. "blake3".
+func ParseHashType(name string) (uint64, error) {
+	code, ok := hashNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported hash function %q", name)
+	}
+	return code, nil
+}
+
+// HashName returns the name of the hash function with the given multihash code, as accepted by
+// ParseHashType.
+func HashName(code uint64) string {
+	for name, c := range hashNames {
+		if c == code {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%x", code)
+}
+
+func isSupportedHashType(code uint64) bool {
+	for _, c := range hashNames {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultCidPrefix returns the prefix of the CIDs of new nodes, using DefaultHashType. Its codec is
+// that of directory nodes, and is replaced by the raw codec for raw nodes.
+func DefaultCidPrefix() cid.Prefix {
+	return cid.Prefix{
+		Version:  1,
+		Codec:    cid.DagProtobuf,
+		MhType:   DefaultHashType,
+		MhLength: -1,
+	}
+}
+
+// NewHasher returns a hash.Hash computing the multihash function of h, for verifying content
+// against h.
+func NewHasher(h multihash.Multihash) (hash.Hash, *multihash.DecodedMultihash, error) {
+	decoded, err := multihash.Decode(h)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isSupportedHashType(decoded.Code) {
+		return nil, nil, fmt.Errorf("unsupported hash function 0x%x", decoded.Code)
+	}
+	hasher, err := multihash.GetHasher(decoded.Code)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hasher, decoded, nil
+}
+
+// SumHash returns the multihash of b, using the same hash function and digest length as h.
+func SumHash(h multihash.Multihash, b []byte) (multihash.Multihash, error) {
+	hasher, decoded, err := NewHasher(h)
+	if err != nil {
+		return nil, err
+	}
+	hasher.Write(b)
+	return encodeDigest(hasher.Sum(nil), decoded)
+}
+
+// VerifyHash returns a *HashMismatchError if b does not match h.
+func VerifyHash(h multihash.Multihash, b []byte) error {
+	actual, err := SumHash(h, b)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(actual, h) {
+		return &HashMismatchError{Expected: h.String(), Actual: actual.String()}
+	}
+	return nil
+}
+
+// EncodeDigest returns the multihash of the digest computed by a hasher returned by NewHasher for h.
+func EncodeDigest(digest []byte, h multihash.Multihash) (multihash.Multihash, error) {
+	decoded, err := multihash.Decode(h)
+	if err != nil {
+		return nil, err
+	}
+	return encodeDigest(digest, decoded)
+}
+
+func encodeDigest(digest []byte, decoded *multihash.DecodedMultihash) (multihash.Multihash, error) {
+	if len(digest) < decoded.Length {
+		return nil, multihash.ErrLenTooLarge
+	}
+	b, err := multihash.Encode(digest[:decoded.Length], decoded.Code)
+	if err != nil {
+		return nil, err
+	}
+	return multihash.Multihash(b), nil
+}
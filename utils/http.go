@@ -0,0 +1,163 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultMaxRetries is the number of times idempotent requests are retried by default.
+	DefaultMaxRetries = 4
+	// initialBackoff is the delay before the first retry; it doubles after each retry.
+	initialBackoff = 200 * time.Millisecond
+	maxBackoff     = 10 * time.Second
+)
+
+// DefaultHTTPClient bounds the time spent connecting and waiting for responses, but not the time
+// spent transferring bodies, so that large objects can be streamed.
+var DefaultHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   16,
+	},
+}
+
+// ErrUnauthorized is returned when the server rejects the credentials of a request, or their lack.
+var ErrUnauthorized = fmt.Errorf("unauthorized")
+
+// ServerError is returned when the server fails with a 5xx status.
+type ServerError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server error: %s", e.Status)
+}
+
+// HashMismatchError is returned when the content of an object does not match its expected hash.
+type HashMismatchError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *HashMismatchError) Error() string {
+	return fmt.Sprintf("mismatching hashes: wanted: %s, got: %s", e.Expected, e.Actual)
+}
+
+// HTTPClient sends requests to an Ent server.
+type HTTPClient struct {
+	// Client is used to send requests; if nil, DefaultHTTPClient is used.
+	Client *http.Client
+	// Token, if set, is sent as a bearer token with every request.
+	Token string
+	// MaxRetries is the number of times idempotent requests are retried after transport errors or
+	// 5xx responses, with exponential backoff. If zero, DefaultMaxRetries is used; if negative,
+	// requests are not retried.
+	MaxRetries int
+}
+
+// Do sends a request with the given body, which may be nil, and returns the response for any
+// status; see CheckStatus. Requests are only retried if idempotent, until ctx is done.
+func (c HTTPClient) Do(ctx context.Context, method string, url string, header http.Header, body []byte, idempotent bool) (*http.Response, error) {
+	maxRetries := c.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = DefaultMaxRetries
+	}
+	if !idempotent || maxRetries < 0 {
+		maxRetries = 0
+	}
+	backoff := initialBackoff
+	for attempt := 0; ; attempt++ {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		res, err := c.DoReader(ctx, method, url, header, r)
+		retry := err != nil || res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+		if !retry || attempt >= maxRetries || ctx.Err() != nil {
+			return res, err
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// DoReader is like Do, but streams the body from r, and is therefore never retried.
+func (c HTTPClient) DoReader(ctx context.Context, method string, url string, header http.Header, r io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := c.Client
+	if client == nil {
+		client = DefaultHTTPClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send %s request to %s: %w", method, url, err)
+	}
+	return res, nil
+}
+
+// CheckStatus returns nil for 2xx responses, ErrUnauthorized wrapped with the status for 401 and
+// 403 responses, *ServerError for 5xx responses, and a generic error otherwise. Callers should
+// check for statuses with a specific meaning, e.g. 404, first.
+func CheckStatus(res *http.Response) error {
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %s", ErrUnauthorized, res.Status)
+	case res.StatusCode >= 500:
+		return &ServerError{
+			StatusCode: res.StatusCode,
+			Status:     res.Status,
+		}
+	default:
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+}
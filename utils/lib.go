@@ -18,6 +18,7 @@ package utils
 import (
 	"encoding/hex"
 	"fmt"
+	"strings"
 
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
@@ -27,34 +28,46 @@ import (
 
 func NewProtoNode() *merkledag.ProtoNode {
 	node := merkledag.ProtoNode{}
-	node.SetCidBuilder(merkledag.V1CidPrefix())
+	node.SetCidBuilder(DefaultCidPrefix())
 	return &node
 }
 
+// ParseProtoNode parses b as a directory node, whose CID uses DefaultHashType.
 func ParseProtoNode(b []byte) (*merkledag.ProtoNode, error) {
+	return parseProtoNode(b, DefaultCidPrefix())
+}
+
+// ParseRawNode parses b as a raw node, whose CID uses DefaultHashType.
+func ParseRawNode(b []byte) (*merkledag.RawNode, error) {
+	return parseRawNode(b, DefaultCidPrefix())
+}
+
+func parseProtoNode(b []byte, prefix cid.Prefix) (*merkledag.ProtoNode, error) {
 	node, err := merkledag.DecodeProtobuf(b)
 	if err != nil {
 		return nil, err
 	}
-	node.SetCidBuilder(merkledag.V1CidPrefix())
+	node.SetCidBuilder(prefix)
 	return node, nil
 }
 
-func ParseRawNode(b []byte) (*merkledag.RawNode, error) {
-	node, err := merkledag.NewRawNodeWPrefix(b, merkledag.V1CidPrefix())
+func parseRawNode(b []byte, prefix cid.Prefix) (*merkledag.RawNode, error) {
+	node, err := merkledag.NewRawNodeWPrefix(b, prefix)
 	if err != nil {
 		return nil, err
 	}
 	return node, nil
 }
 
+// ParseNodeFromBytes parses b as the node with CID c, using the codec and hash function of c.
 func ParseNodeFromBytes(c cid.Cid, b []byte) (format.Node, error) {
-	codec := c.Prefix().Codec
+	prefix := c.Prefix()
+	codec := prefix.Codec
 	switch codec {
 	case cid.DagProtobuf:
-		return ParseProtoNode(b)
+		return parseProtoNode(b, prefix)
 	case cid.Raw:
-		return ParseRawNode(b)
+		return parseRawNode(b, prefix)
 	default:
 		return nil, fmt.Errorf("invalid codec: %d (%s)", codec, cid.CodecToStr[codec])
 	}
@@ -68,15 +81,67 @@ func GetLink(node *merkledag.ProtoNode, name string) (cid.Cid, error) {
 	return link.Cid, nil
 }
 
+// SetLink points the named entry of a directory node to hash. If the entry already exists, it is
+// replaced along with its metadata, which is reset to the default.
 func SetLink(node *merkledag.ProtoNode, name string, hash cid.Cid) error {
 	node.RemoveNodeLink(name) // Ignore errors
-	return node.AddRawLink(name, &format.Link{
+	err := node.AddRawLink(name, &format.Link{
 		Cid: hash,
 	})
+	if err != nil {
+		return err
+	}
+	return SetMetadata(node, name, Metadata{})
+}
+
+// UpdateLink is like SetLink, but keeps the metadata of the entry, e.g. when replacing a
+// subdirectory with a modified version of itself.
+func UpdateLink(node *merkledag.ProtoNode, name string, hash cid.Cid) error {
+	metadata, err := GetMetadata(node)
+	if err != nil {
+		return err
+	}
+	err = SetLink(node, name, hash)
+	if err != nil {
+		return err
+	}
+	return SetMetadata(node, name, metadata[name])
 }
 
+// RemoveLink removes the named entry from a directory node, along with its metadata.
 func RemoveLink(node *merkledag.ProtoNode, name string) error {
-	return node.RemoveNodeLink(name)
+	err := node.RemoveNodeLink(name)
+	if err != nil {
+		return err
+	}
+	return SetMetadata(node, name, Metadata{})
+}
+
+// CheckLinkName returns an error if name cannot be the name of an entry of a directory node, i.e. if
+// it is empty, "." or "..", or contains a slash, which would let it refer to a path outside of the
+// directory once written to disk.
+func CheckLinkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid entry name %q", name)
+	}
+	return nil
+}
+
+// CheckLinks returns an error if the names of the links of a directory node are not all valid
+// entry names, or if two links have the same name.
+func CheckLinks(node *merkledag.ProtoNode) error {
+	names := map[string]bool{}
+	for _, l := range node.Links() {
+		err := CheckLinkName(l.Name)
+		if err != nil {
+			return err
+		}
+		if names[l.Name] {
+			return fmt.Errorf("duplicate entry name %q", l.Name)
+		}
+		names[l.Name] = true
+	}
+	return nil
 }
 
 func Hash(c cid.Cid) string {
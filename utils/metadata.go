@@ -0,0 +1,114 @@
+//
+// Copyright 2021 The Ent Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ipfs/go-merkledag"
+)
+
+const (
+	DefaultFileMode os.FileMode = 0644
+	DefaultDirMode  os.FileMode = 0755
+)
+
+// directoryDataPrefix prefixes the data of DAG-PB directory nodes that record the metadata of some
+// of their entries, followed by a JSON object keyed by entry name. Entries that are not in the
+// object, as well as all the entries of directories without data, have the default metadata, so
+// that directories without special entries have the same hash as before metadata was recorded.
+var directoryDataPrefix = []byte("ent:dir:")
+
+// Metadata is the metadata of an entry of a directory, other than its name and content.
+type Metadata struct {
+	// Mode holds the permission bits of the entry. If zero, the entry has DefaultFileMode or
+	// DefaultDirMode.
+	Mode uint32 `json:"mode,omitempty"`
+	// Symlink is true if the entry is a symbolic link, whose target is the content of the raw
+	// node that the entry points to.
+	Symlink bool `json:"symlink,omitempty"`
+}
+
+// NewMetadata returns the metadata of a local file, as returned by os.Lstat.
+func NewMetadata(info os.FileInfo) Metadata {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return Metadata{Symlink: true}
+	}
+	mode := info.Mode().Perm()
+	if (info.IsDir() && mode == DefaultDirMode) || (!info.IsDir() && mode == DefaultFileMode) {
+		return Metadata{}
+	}
+	return Metadata{Mode: uint32(mode)}
+}
+
+// FileMode returns the permission bits of the entry, which is a directory if isDir.
+func (m Metadata) FileMode(isDir bool) os.FileMode {
+	if m.Mode != 0 {
+		return os.FileMode(m.Mode).Perm()
+	}
+	if isDir {
+		return DefaultDirMode
+	}
+	return DefaultFileMode
+}
+
+// GetMetadata returns the metadata of the entries of a directory node that do not have the default
+// metadata.
+func GetMetadata(node *merkledag.ProtoNode) (map[string]Metadata, error) {
+	metadata := map[string]Metadata{}
+	data := node.Data()
+	if !bytes.HasPrefix(data, directoryDataPrefix) {
+		return metadata, nil
+	}
+	err := json.Unmarshal(data[len(directoryDataPrefix):], &metadata)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse directory metadata: %v", err)
+	}
+	return metadata, nil
+}
+
+// SetMetadata sets the metadata of the named entry of a directory node.
+func SetMetadata(node *merkledag.ProtoNode, name string, m Metadata) error {
+	metadata, err := GetMetadata(node)
+	if err != nil {
+		return err
+	}
+	if m == (Metadata{}) {
+		if _, ok := metadata[name]; !ok {
+			return nil
+		}
+		delete(metadata, name)
+	} else {
+		if len(node.Data()) > 0 && !bytes.HasPrefix(node.Data(), directoryDataPrefix) {
+			return fmt.Errorf("node is not a directory")
+		}
+		metadata[name] = m
+	}
+	if len(metadata) == 0 {
+		node.SetData(nil)
+		return nil
+	}
+	// Map keys are sorted, so the encoding is deterministic.
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	node.SetData(append(append([]byte{}, directoryDataPrefix...), b...))
+	return nil
+}